@@ -0,0 +1,257 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package cmd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect pghurler's configuration",
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the effective connection settings",
+	Long: `Show the effective connection settings and where they come from,
+with passwords masked.
+
+` + connectionHelp + `
+
+` + profileHelp,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE:         runConfigShow,
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+}
+
+// profileHelp describes profiles, for the help of commands that use them.
+const profileHelp = `A profile is a named section of the config file whose keys override the
+top-level ones, so that one file can hold the connection settings and
+defaults of several environments. The profile in use is --profile, else
+the PGHURLER_PROFILE environment variable, else the profile of the --feed,
+else the profile key of the config file:
+
+  profile: dev
+  profiles:
+    dev:
+      dsn: postgres://loader@localhost/dev
+    prod:
+      dsn: postgres://loader@db.example.com/prod
+      sslmode: verify-full
+      load:
+        max_delete_percent: 2`
+
+// activeProfile is the name of the profile in use, if any.
+var activeProfile string
+
+// applyProfile merges the settings of the profile in use over the top-level
+// ones of the config file.
+func applyProfile(cmd *cobra.Command, args []string) error {
+	name := viper.GetString("profile")
+	if !cmd.Flags().Changed("profile") && os.Getenv("PGHURLER_PROFILE") == "" {
+		feed, err := selectedFeed(cmd)
+		if err != nil {
+			return err
+		}
+		if feed != nil && feed.Profile != "" {
+			name = feed.Profile
+		}
+	}
+	if name == "" {
+		return nil
+	}
+
+	settings, err := profileSettings(name)
+	if err != nil {
+		return err
+	}
+	activeProfile = name
+	return viper.MergeConfigMap(settings)
+}
+
+// profileSettings returns the settings of the named profile.
+func profileSettings(name string) (map[string]interface{}, error) {
+	profile, ok := viper.GetStringMap("profiles")[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("no profile '%s' in the config file", name)
+	}
+	settings, err := cast.ToStringMapE(profile)
+	if err != nil {
+		return nil, fmt.Errorf("profile '%s' is not a section of settings", name)
+	}
+	return settings, nil
+}
+
+// fromConfig is the annotation flagDefaults marks the flags it sets with.
+const fromConfig = "pghurler_from_config"
+
+// flagDefaults sets the flags of cmd not given on the command line from the
+// keys of a section of the config file, named like the flags but with
+// underscores.
+func flagDefaults(cmd *cobra.Command, section string) error {
+	var err error
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		key := section + "." + strings.Replace(flag.Name, "-", "_", -1)
+		if err != nil || flag.Changed || !viper.IsSet(key) {
+			return
+		}
+		values := []string{viper.GetString(key)}
+		switch flag.Value.Type() {
+		case "stringSlice":
+			values = []string{strings.Join(viper.GetStringSlice(key), ",")}
+		case "stringArray":
+			values = viper.GetStringSlice(key)
+		}
+		for _, v := range values {
+			if e := flag.Value.Set(v); e != nil {
+				err = fmt.Errorf("%s: %s", key, e)
+				return
+			}
+		}
+		flag.Changed = true
+		err = cmd.Flags().SetAnnotation(flag.Name, fromConfig, []string{key})
+	})
+	return err
+}
+
+// givenFlag reports whether the flag name of cmd was given on the command
+// line, rather than left out or set by flagDefaults.
+func givenFlag(cmd *cobra.Command, name string) bool {
+	flag := cmd.Flags().Lookup(name)
+	return flag != nil && flag.Changed && flag.Annotations[fromConfig] == nil
+}
+
+// secretMask is shown in place of a password or other secret.
+const secretMask = "********"
+
+// mask replaces a secret, if any, with secretMask.
+func mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return secretMask
+}
+
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// maskDSN masks the password of a connection string.
+func maskDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), secretMask)
+		}
+		q := u.Query()
+		if q.Get("password") != "" {
+			q.Set("password", secretMask)
+			u.RawQuery = q.Encode()
+		}
+		// The URL escapes the mask's asterisks; show them as elsewhere.
+		return strings.Replace(u.String(), url.QueryEscape(secretMask), secretMask, -1)
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}"+secretMask)
+}
+
+// describeTLS tells how a connection with config is secured.
+func describeTLS(config *tls.Config) string {
+	var s string
+	switch {
+	case config == nil:
+		return "off"
+	case config.VerifyPeerCertificate != nil:
+		s = "on, server certificate verified"
+	case !config.InsecureSkipVerify:
+		s = "on, server certificate and host name verified"
+	default:
+		s = "on, server certificate not verified"
+	}
+	if config.RootCAs != nil {
+		s += " against sslrootcert"
+	} else if config.VerifyPeerCertificate != nil || !config.InsecureSkipVerify {
+		s += " against the system CAs"
+	}
+	if len(config.Certificates) > 0 {
+		s += ", client certificate"
+	}
+	return s
+}
+
+func runConfigShow(cmd *cobra.Command, args []string) error {
+	config, err := connConfig()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE")
+	fmt.Fprintf(w, "host\t%s\n", config.Host)
+	fmt.Fprintf(w, "port\t%d\n", config.Port)
+	fmt.Fprintf(w, "database\t%s\n", config.Database)
+	fmt.Fprintf(w, "user\t%s\n", config.User)
+	fmt.Fprintf(w, "password\t%s\n", mask(config.Password))
+	security := describeTLS(config.TLSConfig)
+	if len(config.Fallbacks) > 0 {
+		security += ", else " + describeTLS(config.Fallbacks[len(config.Fallbacks)-1].TLSConfig)
+	}
+	fmt.Fprintf(w, "tls\t%s\n", security)
+	if config.ConnectTimeout > 0 {
+		fmt.Fprintf(w, "connect_timeout\t%s\n", config.ConnectTimeout)
+	}
+	var params []string
+	for k := range config.RuntimeParams {
+		params = append(params, k)
+	}
+	sort.Strings(params)
+	for _, k := range params {
+		fmt.Fprintf(w, "%s\t%s\n", k, config.RuntimeParams[k])
+	}
+	w.Flush()
+
+	fmt.Println()
+	fmt.Println("Sources, highest precedence first:")
+	if activeProfile != "" {
+		fmt.Printf("  profile: %s\n", activeProfile)
+	}
+	if dsn, from := connString(); dsn != "" {
+		fmt.Printf("  connection string (%s): %s\n", from, maskDSN(dsn))
+	} else {
+		fmt.Println("  connection string: none")
+	}
+	for _, name := range []string{"PGSERVICE", "PGSERVICEFILE", "PGSYSCONFDIR"} {
+		if v := os.Getenv(name); v != "" {
+			fmt.Printf("  %s=%s\n", name, v)
+		}
+	}
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if !strings.HasPrefix(name, "PG") || strings.HasPrefix(name, "PGHURLER_") || name == "PGSERVICE" || name == "PGSERVICEFILE" || name == "PGSYSCONFDIR" {
+			continue
+		}
+		value := os.Getenv(name)
+		if name == "PGPASSWORD" {
+			value = mask(value)
+		}
+		fmt.Printf("  %s=%s\n", name, value)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// connectionHelp describes where connection settings come from, for the
+// help of commands that connect.
+const connectionHelp = `The connection string is taken from the --dsn flag, else the PGHURLER_DSN
+environment variable, else the dsn key of the config file. It may be a URL
+(postgres://user@host/db) or keyword/value pairs (host=db user=loader).
+Settings it leaves out come from the service it names, or PGSERVICE, in
+the service file; then from the PG* environment variables (PGHOST, PGPORT,
+PGDATABASE, PGUSER, PGPASSWORD, ...); then from the defaults. Without a
+password, one is looked up in ~/.pgpass or PGPASSFILE. The service file is
+PGSERVICEFILE, else ~/.pg_service.conf, else pg_service.conf in
+PGSYSCONFDIR.
+
+TLS is set by sslmode: disable, allow, prefer (the default), require,
+verify-ca or verify-full. The verify modes check the server's certificate
+against sslrootcert, else the system's CA certificates; verify-full also
+checks its host name. A client certificate is given by sslcert and its
+key by sslkey, which must not be readable by group or others. The --ssl*
+flags, PGHURLER_SSLMODE and the like, or the keys of the same name in the
+config file, override the connection string and PGSSLMODE, PGSSLROOTCERT,
+PGSSLCERT and PGSSLKEY.`
+
+// sslSettings are the TLS settings that flags and config keys override.
+var sslSettings = []string{"sslmode", "sslrootcert", "sslcert", "sslkey"}
+
+// connString returns the connection string and where it was taken from.
+func connString() (string, string) {
+	dsn := viper.GetString("dsn")
+	switch {
+	case dsn == "":
+		return "", ""
+	case rootCmd.PersistentFlags().Changed("dsn"):
+		return dsn, "--dsn flag"
+	case os.Getenv("PGHURLER_DSN") != "":
+		return dsn, "PGHURLER_DSN environment variable"
+	default:
+		return dsn, "config file"
+	}
+}
+
+// connConfig parses the effective connection settings.
+func connConfig() (*pgx.ConnConfig, error) {
+	return parseConnConfig(viper.GetString)
+}
+
+// profileConnConfig parses the connection settings of the named profile,
+// taking those it leaves out from the flags, environment and config file as
+// usual. Flags given on the command line win over the profile, as they do
+// over the profile in use. An empty name gives the effective settings.
+func profileConnConfig(name string) (*pgx.ConnConfig, error) {
+	if name == "" {
+		return connConfig()
+	}
+	settings, err := profileSettings(name)
+	if err != nil {
+		return nil, err
+	}
+	return parseConnConfig(func(key string) string {
+		if v, ok := settings[key]; ok && !rootCmd.PersistentFlags().Changed(key) {
+			return cast.ToString(v)
+		}
+		return viper.GetString(key)
+	})
+}
+
+// parseConnConfig parses the connection settings that setting gives.
+func parseConnConfig(setting func(key string) string) (*pgx.ConnConfig, error) {
+	dsn := setting("dsn")
+
+	overrides := map[string]string{}
+	if file := sysconfServiceFile(); file != "" && !namesServiceFile(dsn) {
+		overrides["servicefile"] = file
+	}
+	for _, key := range sslSettings {
+		if v := setting(key); v != "" {
+			overrides[key] = v
+		}
+	}
+	dsn, err := withSettings(dsn, overrides)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyFile(dsn); err != nil {
+		return nil, err
+	}
+	return pgx.ParseConfig(dsn)
+}
+
+// withSettings adds settings to a connection string, replacing any it has.
+func withSettings(dsn string, settings map[string]string) (string, error) {
+	if len(settings) == 0 {
+		return dsn, nil
+	}
+	if isURL(dsn) {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", fmt.Errorf("invalid connection string: %s", err)
+		}
+		q := u.Query()
+		for key, v := range settings {
+			q.Set(key, v)
+		}
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+
+	// Later keywords win over earlier ones.
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(dsn)
+	for _, key := range keys {
+		quoted := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(settings[key])
+		fmt.Fprintf(&b, " %s='%s'", key, quoted)
+	}
+	return b.String(), nil
+}
+
+// namesServiceFile reports whether a connection string sets the service
+// file itself.
+func namesServiceFile(dsn string) bool {
+	if isURL(dsn) {
+		u, err := url.Parse(dsn)
+		return err == nil && u.Query().Get("servicefile") != ""
+	}
+	_, ok := keywordValue(dsn, "servicefile")
+	return ok
+}
+
+func isURL(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// checkKeyFile refuses a client key others may read, as libpq does.
+func checkKeyFile(dsn string) error {
+	key := os.Getenv("PGSSLKEY")
+	if isURL(dsn) {
+		if u, err := url.Parse(dsn); err == nil && u.Query().Get("sslkey") != "" {
+			key = u.Query().Get("sslkey")
+		}
+	} else if v, ok := keywordValue(dsn, "sslkey"); ok {
+		key = v
+	}
+	if key == "" {
+		return nil
+	}
+	info, err := os.Stat(key)
+	if err != nil {
+		return fmt.Errorf("client key: %s", err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("client key %s has group or world access; its permissions should be u=rw (0600) or less", key)
+	}
+	return nil
+}
+
+// sysconfServiceFile returns the service file in PGSYSCONFDIR when it is
+// the one libpq would read but pgx would not: no PGSERVICEFILE is set and
+// there is no ~/.pg_service.conf. It is passed to pgx as the servicefile
+// setting.
+func sysconfServiceFile() string {
+	dir := os.Getenv("PGSYSCONFDIR")
+	if dir == "" || os.Getenv("PGSERVICEFILE") != "" {
+		return ""
+	}
+	if home, err := homedir.Dir(); err == nil {
+		if _, err := os.Stat(filepath.Join(home, ".pg_service.conf")); err == nil {
+			return ""
+		}
+	}
+	return filepath.Join(dir, "pg_service.conf")
+}
+
+// connect opens a connection with the effective connection settings.
+func connect(ctx context.Context) (*pgx.Conn, error) {
+	config, err := connConfig()
+	if err != nil {
+		return nil, err
+	}
+	return pgx.ConnectConfig(ctx, config)
+}
+
+// connectProfile opens a connection with the settings of the named profile.
+func connectProfile(ctx context.Context, name string) (*pgx.Conn, error) {
+	config, err := profileConnConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.ConnectConfig(ctx, config)
+}
+
+var keywordPair = regexp.MustCompile(`(\w+)\s*=\s*('(?:[^'\\]|\\.)*'|\S+)`)
+
+// keywordValue returns the last value of key in a keyword/value connection
+// string.
+func keywordValue(dsn string, key string) (string, bool) {
+	var value string
+	var found bool
+	for _, m := range keywordPair.FindAllStringSubmatch(dsn, -1) {
+		if m[1] != key {
+			continue
+		}
+		value, found = m[2], true
+		if strings.HasPrefix(value, "'") {
+			value = strings.NewReplacer(`\\`, `\`, `\'`, `'`).Replace(value[1 : len(value)-1])
+		}
+	}
+	return value, found
+}
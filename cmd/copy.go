@@ -0,0 +1,106 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/raginjason/pghurler/hurler"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+// copyCmd represents the copy command
+var copyCmd = &cobra.Command{
+	Use:   "copy",
+	Short: "Copy a table from one server to another",
+	Long: `Copy the rows of a table from one server to another, streaming COPY TO
+STDOUT on the source into COPY FROM STDIN on the target, without an
+intermediate file:
+
+  pghurler copy --from prod --to dev --table crm.people --where "region = 'eu'" --truncate
+
+--from and --to name profiles of the config file holding the connection
+settings of each server; either defaults to the profile in use. Settings a
+profile leaves out are taken from the environment and config file as for
+other commands, and --dsn and the --ssl* flags, when given, apply to both
+sides over their profiles.
+
+Every column the source and target tables share, but for the target's
+generated columns, is copied into the target column of the same name,
+unless --columns lists those to copy, each as a name or as source:target
+to copy into a column of another name. The target is --table unless
+--target names another. --where is an SQL condition on the source table
+choosing the rows to copy.
+
+The source session is made read only. The target is written in a single
+transaction, emptied first with --truncate, so a failed copy leaves it as
+it was.
+
+` + connectionHelp + `
+
+` + profileHelp,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE:         runCopy,
+}
+
+func init() {
+	rootCmd.AddCommand(copyCmd)
+
+	copyCmd.Flags().String("from", "", "profile of the source server (default the profile in use)")
+	copyCmd.Flags().String("to", "", "profile of the target server (default the profile in use)")
+	copyCmd.Flags().StringP("table", "t", "", "table to copy, optionally schema-qualified")
+	copyCmd.Flags().String("target", "", "table to copy into (default --table)")
+	copyCmd.Flags().StringSlice("columns", nil, "columns to copy, each name or source:target, comma separated (default all)")
+	copyCmd.Flags().String("where", "", "SQL condition choosing the rows of the source to copy")
+	copyCmd.Flags().Bool("truncate", false, "empty the target table before copying")
+}
+
+func runCopy(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	flags := cmd.Flags()
+
+	var opts hurler.TableCopyOptions
+	opts.Table, _ = flags.GetString("table")
+	opts.Target, _ = flags.GetString("target")
+	opts.Where, _ = flags.GetString("where")
+	opts.Truncate, _ = flags.GetBool("truncate")
+	if opts.Table == "" {
+		return errors.New("give the --table to copy")
+	}
+	specs, _ := flags.GetStringSlice("columns")
+	columns, err := hurler.ParseColumnMap(specs)
+	if err != nil {
+		return err
+	}
+	opts.Columns = columns
+
+	fromProfile, _ := flags.GetString("from")
+	toProfile, _ := flags.GetString("to")
+	from, err := connectProfile(ctx, fromProfile)
+	if err != nil {
+		return fmt.Errorf("source: %s", err)
+	}
+	defer from.Close(ctx)
+	to, err := connectProfile(ctx, toProfile)
+	if err != nil {
+		return fmt.Errorf("target: %s", err)
+	}
+	defer to.Close(ctx)
+
+	start := time.Now()
+	res, err := hurler.CopyTable(ctx, from, to, opts)
+	if err != nil {
+		return err
+	}
+	target := opts.Target
+	if target == "" {
+		target = opts.Table
+	}
+	fmt.Printf("%s: %d rows copied into %s in %s\n", opts.Table, res.Rows, target, time.Since(start).Round(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,160 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/raginjason/pghurler/hurler"
+	"github.com/spf13/cobra"
+	"strings"
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff <file>",
+	Short: "Compare a file against a table",
+	Long: `Compare a file against a table, pairing records with rows by --key, and
+report the rows only in the file, those only in the table, and those whose
+values differ, column by column, with examples of each.
+
+The file is read as load reads it, with the same NULL markers, control
+records, transforms, filters and conversions from the flags and the config
+file. Its records are copied into a temporary table with the types of the
+table's columns, so values compare as the table's types do: 1.50 in the
+file matches 1.5 in a numeric column. Nothing is written; the transaction
+is rolled back.
+
+The columns of the file other than the key are compared, except those given
+to --ignore. Columns of the table that are not in the file are not. The key
+must be unique in both the file and the table.
+
+The table is --table, else that of the --feed, else routed by file name as
+with load, and the key is --key, else that of the feed or route. The command
+fails if the file and the table differ, so it can prove a recovery.
+
+` + connectionHelp,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE:         runDiff,
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringP("table", "t", "", "table to compare against, optionally schema-qualified (default routed by file name)")
+	diffCmd.Flags().String("feed", "", "read the file as the feed of this name in the config file")
+	diffCmd.Flags().StringSliceP("key", "k", nil, "key columns pairing records with rows, comma separated")
+	diffCmd.Flags().StringSlice("ignore", nil, "columns of the file not to compare, comma separated")
+	diffCmd.Flags().Int("examples", 10, "rows to list of each kind of difference")
+	addSourceFlags(diffCmd)
+}
+
+func runDiff(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	source, err := sourceOptions(cmd)
+	if err != nil {
+		return err
+	}
+	var load hurler.LoadOptions
+	load.Table, _ = cmd.Flags().GetString("table")
+	jobs, err := fileJobs(cmd, args, source, load)
+	if err != nil {
+		return err
+	}
+	job := jobs[0]
+
+	opts := hurler.DiffOptions{Table: job.Load.Table, Key: job.Load.Key}
+	if cmd.Flags().Changed("key") {
+		opts.Key, _ = cmd.Flags().GetStringSlice("key")
+	}
+	if len(opts.Key) == 0 {
+		return errors.New("no key to pair records with rows: give --key")
+	}
+	opts.Ignore, _ = cmd.Flags().GetStringSlice("ignore")
+	opts.Examples, _ = cmd.Flags().GetInt("examples")
+
+	conn, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+
+	src, err := hurler.OpenSource(job.Path, job.Source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	d, err := hurler.DiffSource(ctx, conn, src, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %s", job.Path, err)
+	}
+	printDiff(job.Path, opts, d)
+
+	if !d.Same() {
+		return fmt.Errorf("%s and %s differ", job.Path, opts.Table)
+	}
+	return nil
+}
+
+func printDiff(path string, opts hurler.DiffOptions, d *hurler.Diff) {
+	fmt.Printf("%s: %d rows, %s: %d rows, by key (%s)\n", path, d.FileRows, opts.Table, d.TableRows, strings.Join(opts.Key, ", "))
+	fmt.Printf("  %d match\n", d.Matching())
+	fmt.Printf("  %d only in the file\n", d.OnlyInFile)
+	fmt.Printf("  %d only in the table\n", d.OnlyInTable)
+	fmt.Printf("  %d differ", d.Differing)
+	var columns []string
+	for _, c := range d.Columns {
+		if c.Rows > 0 {
+			columns = append(columns, fmt.Sprintf("%s in %d", c.Column, c.Rows))
+		}
+	}
+	if len(columns) > 0 {
+		fmt.Printf(": %s", strings.Join(columns, ", "))
+	}
+	fmt.Println()
+
+	if len(d.FileOnlyKeys) > 0 {
+		fmt.Printf("\nOnly in the file:\n")
+		for _, key := range d.FileOnlyKeys {
+			fmt.Printf("  %s\n", diffKey(key))
+		}
+	}
+	if len(d.TableOnlyKeys) > 0 {
+		fmt.Printf("\nOnly in the table:\n")
+		for _, key := range d.TableOnlyKeys {
+			fmt.Printf("  %s\n", diffKey(key))
+		}
+	}
+	if len(d.Differences) > 0 {
+		fmt.Printf("\nDiffering:\n")
+		for _, row := range d.Differences {
+			fmt.Printf("  %s\n", diffKey(row.Key))
+			for _, v := range row.Values {
+				fmt.Printf("    %s: %s in the file, %s in the table\n", v.Column, diffValue(v.File), diffValue(v.Table))
+			}
+		}
+	}
+}
+
+// diffKey shows the values of a key.
+func diffKey(key []*string) string {
+	values := make([]string, len(key))
+	for i, v := range key {
+		values[i] = diffValue(v)
+	}
+	return "(" + strings.Join(values, ", ") + ")"
+}
+
+// diffValue shows a value quoted, so whitespace is seen, or NULL.
+func diffValue(v *string) string {
+	if v == nil {
+		return "NULL"
+	}
+	return fmt.Sprintf("%q", *v)
+}
@@ -0,0 +1,88 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/raginjason/pghurler/hurler"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export <file>",
+	Short: "Unload a table or query into a delimited file",
+	Long: `Unload a table or query into a delimited file with COPY TO STDOUT, the
+reverse of load.
+
+Give either --table, optionally with --columns, or --query:
+
+  pghurler export --table staging.events events.csv
+  pghurler export --query 'select id, name from events where day = current_date' out.tsv.gz
+
+The delimiter is derived from the file extension as for load, unless
+--delimiter is given, and a file ending in .gz is compressed. The file is
+written in CSV style, quoting values holding the delimiter, quotes or
+newlines, with a header row of column names unless --no-header. NULLs are
+written as --null, empty by default, in which case empty strings are
+written quoted.
+
+The file is written under a temporary name and renamed once complete, so a
+failed export leaves nothing behind. The session is made read only.
+
+` + connectionHelp,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE:         runExport,
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringP("table", "t", "", "table to export, optionally schema-qualified")
+	exportCmd.Flags().StringSlice("columns", nil, "columns of the table to export, in order (default all)")
+	exportCmd.Flags().StringP("query", "q", "", "query whose result to export")
+	exportCmd.Flags().StringP("delimiter", "d", "", `field delimiter, e.g. "," or "\t" (default derived from the file extension)`)
+	exportCmd.Flags().String("null", "", "value written for NULL")
+	exportCmd.Flags().Bool("no-header", false, "leave out the header row")
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	flags := cmd.Flags()
+
+	var opts hurler.ExportOptions
+	opts.Table, _ = flags.GetString("table")
+	opts.Columns, _ = flags.GetStringSlice("columns")
+	opts.Query, _ = flags.GetString("query")
+	opts.Null, _ = flags.GetString("null")
+	opts.NoHeader, _ = flags.GetBool("no-header")
+	if delimiter, _ := flags.GetString("delimiter"); delimiter != "" {
+		d, err := parseDelimiter(delimiter)
+		if err != nil {
+			return err
+		}
+		opts.Delimiter = d
+	}
+	if err := hurler.CheckExport(args[0], opts); err != nil {
+		return err
+	}
+
+	conn, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+
+	start := time.Now()
+	res, err := hurler.Export(ctx, conn, args[0], opts)
+	if err != nil {
+		return fmt.Errorf("%s: %s", args[0], err)
+	}
+	fmt.Printf("%s: %d rows exported in %s\n", res.Path, res.Rows, time.Since(start).Round(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/raginjason/pghurler/hurler"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+// headCmd represents the head command
+var headCmd = &cobra.Command{
+	Use:   "head <file>",
+	Short: "Show the first records of a file as they would be loaded",
+	Long: `Show the first records of a file as they would be loaded, aligned under
+their column names with the line each starts on.
+
+The file is read as load reads it, with the delimiter derived from its
+extension unless --delimiter is given, and the same NULL markers, control
+records, transforms, filters and conversions from the flags and the config
+file. Records that fail to parse or convert are listed after the table.
+
+Values that look alike on a terminal are told apart by markers:
+
+  ` + hurler.NullMarker + `   NULL, where an empty value shows as nothing
+  ` + hurler.SpaceMarker + `   a leading or trailing space
+  ` + hurler.TabMarker + `   a tab
+  ` + hurler.NewlineMarker + `   a newline within a quoted value
+  ` + hurler.ReturnMarker + `   a carriage return
+
+Other control and space characters are shown escaped, such as \u00a0 for a
+no-break space, as are the markers' own characters where a value or column
+name holds them, and a backslash shows as \\. Values longer than --width
+characters are cut.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE:         runHead,
+}
+
+func init() {
+	rootCmd.AddCommand(headCmd)
+
+	headCmd.Flags().IntP("lines", "n", 10, "number of records to show")
+	headCmd.Flags().Int("width", 40, "most characters of a value to show; 0 for no limit")
+	addSourceFlags(headCmd)
+}
+
+func runHead(cmd *cobra.Command, args []string) error {
+	n, _ := cmd.Flags().GetInt("lines")
+	width, _ := cmd.Flags().GetInt("width")
+
+	source, err := sourceOptions(cmd)
+	if err != nil {
+		return err
+	}
+	src, err := hurler.OpenSource(args[0], source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	preview, err := hurler.Head(src, n)
+	if err != nil {
+		return fmt.Errorf("%s: %s", args[0], err)
+	}
+
+	header := make([]string, len(preview.Columns))
+	for i, col := range preview.Columns {
+		header[i] = hurler.Visible(col)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "LINE\t%s\n", strings.Join(header, "\t"))
+	for _, rec := range preview.Records {
+		cells := make([]string, len(preview.Columns))
+		for i, col := range preview.Columns {
+			if rec.IsNull(col) {
+				cells[i] = hurler.NullMarker
+			} else {
+				cells[i] = cut(hurler.Visible(rec.Values[col]), width)
+			}
+		}
+		fmt.Fprintf(w, "%d\t%s\n", rec.LineNumber, strings.Join(cells, "\t"))
+	}
+	w.Flush()
+
+	for _, p := range preview.Problems {
+		fmt.Printf("  %s\n", p)
+	}
+	return nil
+}
+
+// cut shortens value to n characters, ending it with an ellipsis. Zero
+// means no limit.
+func cut(value string, n int) string {
+	if n <= 0 || utf8.RuneCountInString(value) <= n {
+		return value
+	}
+	return string([]rune(value)[:n-1]) + "…"
+}
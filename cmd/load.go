@@ -0,0 +1,195 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/raginjason/pghurler/hurler"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// loadCmd represents the load command
+var loadCmd = &cobra.Command{
+	Use:   "load <file|directory|glob>...",
+	Short: "Load delimited files into a table",
+	Long: `Load delimited files into a table with COPY, each in a single transaction.
+
+The first row of a file names the columns, which are loaded into the
+table's columns of the same name. Files may be given as directories or
+glob patterns, and files ending in .gz are decompressed. --mode append
+adds the records, upsert merges them on --key, replace swaps the table's
+content for the file's, and scd2 keeps every version of each row.
+
+NULL markers, control records, transforms, conversions, routes from file
+names to tables, feeds and defaults for these flags are set in the config
+file. The options of a route or feed win over those defaults but not over
+flags given on the command line. Every load is recorded in the
+pghurler_loads table, and a file already loaded into its table, even in
+part, is refused unless --force. A load is all or nothing, except with
+--commit-every or --workers, whose parts commit separately.
+
+` + connectionHelp + `
+
+` + profileHelp,
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	RunE:         runLoad,
+}
+
+func init() {
+	rootCmd.AddCommand(loadCmd)
+
+	loadCmd.Flags().StringP("table", "t", "", "target table, optionally schema-qualified (default routed by file name)")
+	loadCmd.Flags().String("mode", hurler.ModeAppend, "load mode: append, upsert, replace or scd2")
+	loadCmd.Flags().StringSlice("key", nil, "key columns for upsert and scd2, comma separated")
+	loadCmd.Flags().Bool("only-changed", false, "in upsert mode, only update rows whose values changed")
+	loadCmd.Flags().String("replace-strategy", hurler.ReplaceSwap, "in replace mode, swap or truncate")
+	loadCmd.Flags().IntP("jobs", "j", 1, "files to load at once, each over its own connection")
+	loadCmd.Flags().Bool("single-transaction", false, "load all files in one transaction")
+	loadCmd.Flags().Int("workers", 1, "in append mode, connections to load parts of the file over")
+	loadCmd.Flags().Bool("create-partitions", false, "create the partitions the records need and the table lacks")
+	loadCmd.Flags().String("partition-interval", hurler.PartitionMonth, "span of created range partitions: day, month or year")
+	loadCmd.Flags().Bool("leaf-copy", false, "copy records straight into their leaf partitions")
+	loadCmd.Flags().StringSlice("lineage", nil, "lineage columns to add to every row, comma separated, or all")
+	loadCmd.Flags().Bool("force", false, "load a file even if it was already loaded into the table")
+	loadCmd.Flags().String("effective-column", "", "in scd2 mode, file column new versions are valid from")
+	loadCmd.Flags().String("deletes", "", "in upsert mode, soft or hard delete rows absent from the file")
+	loadCmd.Flags().String("deleted-at-column", "deleted_at", "column soft deletes set to the load time")
+	loadCmd.Flags().Float64("max-delete-percent", 10, "refuse to delete more than this share of the table's rows")
+	loadCmd.Flags().Int("retries", 3, "times to retry a load failing on a transient error")
+	loadCmd.Flags().Duration("retry-backoff", time.Second, "longest wait before the first retry, doubling with each")
+	loadCmd.Flags().Duration("retry-max-backoff", 30*time.Second, "longest wait between retries")
+	loadCmd.Flags().Int("commit-every", 0, "in append mode, commit every this many records so a retry resumes after them")
+	loadCmd.Flags().String("feed", "", "load the files as the feed of this name in the config file")
+	addSourceFlags(loadCmd)
+}
+
+func runLoad(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	if err := flagDefaults(cmd, "load"); err != nil {
+		return err
+	}
+	opts, err := sourceOptions(cmd)
+	if err != nil {
+		return err
+	}
+	var load hurler.LoadOptions
+	load.Table, _ = cmd.Flags().GetString("table")
+	load.Mode, _ = cmd.Flags().GetString("mode")
+	load.Key, _ = cmd.Flags().GetStringSlice("key")
+	load.OnlyChanged, _ = cmd.Flags().GetBool("only-changed")
+	load.Replace, _ = cmd.Flags().GetString("replace-strategy")
+	load.Force, _ = cmd.Flags().GetBool("force")
+	load.Workers, _ = cmd.Flags().GetInt("workers")
+	load.CreatePartitions, _ = cmd.Flags().GetBool("create-partitions")
+	load.PartitionInterval, _ = cmd.Flags().GetString("partition-interval")
+	load.LeafCopy, _ = cmd.Flags().GetBool("leaf-copy")
+	load.Lineage, _ = cmd.Flags().GetStringSlice("lineage")
+	if len(load.Lineage) == 1 && load.Lineage[0] == "all" {
+		load.Lineage = hurler.LineageColumns
+	}
+	load.Effective, _ = cmd.Flags().GetString("effective-column")
+	load.Deletes, _ = cmd.Flags().GetString("deletes")
+	load.DeletedAt, _ = cmd.Flags().GetString("deleted-at-column")
+	load.MaxDeletePercent, _ = cmd.Flags().GetFloat64("max-delete-percent")
+	load.CommitEvery, _ = cmd.Flags().GetInt("commit-every")
+
+	var batch hurler.BatchOptions
+	batch.Jobs, _ = cmd.Flags().GetInt("jobs")
+	batch.SingleTransaction, _ = cmd.Flags().GetBool("single-transaction")
+	batch.Connect = connect
+	retries, _ := cmd.Flags().GetInt("retries")
+	batch.Retry.Attempts = retries + 1
+	batch.Retry.Backoff, _ = cmd.Flags().GetDuration("retry-backoff")
+	batch.Retry.MaxBackoff, _ = cmd.Flags().GetDuration("retry-max-backoff")
+
+	paths, err := hurler.ExpandPaths(args)
+	if err != nil {
+		return err
+	}
+	jobs, err := fileJobs(cmd, paths, opts, load)
+	if err != nil {
+		return err
+	}
+
+	results := hurler.LoadBatch(ctx, jobs, batch)
+	if len(results) == 1 {
+		return printResult(results[0])
+	}
+	return printSummary(results)
+}
+
+// printResult reports the load of a single file in detail.
+func printResult(r hurler.JobResult) error {
+	var loaded *hurler.AlreadyLoadedError
+	if errors.As(r.Err, &loaded) {
+		return fmt.Errorf("%s; --force loads it again", r.Err)
+	}
+	if r.Err != nil {
+		return fmt.Errorf("%s: %s%s", r.Path, r.Err, retried(r))
+	}
+
+	res := r.Result
+	fmt.Printf("loaded %d records from %s into %s (load %d)\n", res.Rows, r.Path, r.Load.Table, res.LoadID)
+	switch r.Load.Mode {
+	case hurler.ModeUpsert:
+		fmt.Printf("  inserted %d, updated %d, unchanged %d\n", res.Inserted, res.Updated, res.Rows-res.Inserted-res.Updated)
+	case hurler.ModeSCD2:
+		fmt.Printf("  new %d, changed %d, unchanged %d\n", res.Inserted, res.Updated, res.Rows-res.Inserted-res.Updated)
+	}
+	if r.Load.Deletes != "" {
+		fmt.Printf("  deleted %d (%s)\n", res.Deleted, r.Load.Deletes)
+	}
+	for _, stat := range res.Skipped {
+		fmt.Printf("  skipped %d records: %s\n", stat.Skipped, stat.Rule)
+	}
+	if r.Attempts > 1 {
+		fmt.Printf("  took %d attempts\n", r.Attempts)
+	}
+	return nil
+}
+
+// retried notes how often a load was tried, if more than once.
+func retried(r hurler.JobResult) string {
+	if r.Attempts > 1 {
+		return fmt.Sprintf(" (after %d attempts)", r.Attempts)
+	}
+	return ""
+}
+
+// printSummary reports the loads of several files as a table, one row per
+// file.
+func printSummary(results []hurler.JobResult) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tTABLE\tRECORDS\tINSERTED\tUPDATED\tDELETED\tSKIPPED\tTIME\tSTATUS")
+
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			fmt.Fprintf(w, "%s\t%s\t\t\t\t\t\t%s\t%s%s\n", r.Path, r.Load.Table, r.Duration.Round(time.Millisecond), r.Err, retried(r))
+			continue
+		}
+		var skipped uint64
+		for _, stat := range r.Result.Skipped {
+			skipped += stat.Skipped
+		}
+		res := r.Result
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%s\tok%s\n",
+			r.Path, r.Load.Table, res.Rows, res.Inserted, res.Updated, res.Deleted, skipped, r.Duration.Round(time.Millisecond), retried(r))
+	}
+	w.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/raginjason/pghurler/hurler"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// profileCmd represents the profile command
+var profileCmd = &cobra.Command{
+	Use:   "profile <file>",
+	Short: "Describe the columns of a file",
+	Long: `Describe the columns of a file, to get to know a new feed.
+
+The file is read as load reads it, with the same NULL markers, control
+records, transforms, filters and conversions from the flags and the config
+file. Records that fail to parse or convert are counted and skipped. For
+each column it reports:
+
+  - the NULL and empty values
+  - the distinct values, counted exactly up to --exact-limit of them and
+    estimated with HyperLogLog past it (shown with a ~)
+  - the shortest and longest value, in characters
+  - the narrowest type all values convert to: integer, numeric, date,
+    timestamp, boolean or text
+  - the smallest and largest values, compared as that type
+  - the --top most frequent values, approximate past --exact-limit
+  - the --top most frequent patterns of values, where upper and lower case
+    letters are written A and a and digits 9, with an example of each
+
+--format is table, json or markdown.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE:         runProfile,
+}
+
+func init() {
+	rootCmd.AddCommand(profileCmd)
+
+	profileCmd.Flags().String("format", "table", "output format: table, json or markdown")
+	profileCmd.Flags().Int("top", 5, "most frequent values and patterns to show per column")
+	profileCmd.Flags().Int("exact-limit", 100000, "distinct values per column counted exactly before estimating")
+	addSourceFlags(profileCmd)
+}
+
+func runProfile(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+	var write func(io.Writer, *hurler.Profile) error
+	switch format {
+	case "table":
+		write = writeProfileTable
+	case "json":
+		write = writeProfileJSON
+	case "markdown":
+		write = writeProfileMarkdown
+	default:
+		return fmt.Errorf("unknown format '%s'", format)
+	}
+
+	source, err := sourceOptions(cmd)
+	if err != nil {
+		return err
+	}
+	var opts hurler.ProfileOptions
+	opts.Top, _ = cmd.Flags().GetInt("top")
+	opts.ExactLimit, _ = cmd.Flags().GetInt("exact-limit")
+
+	src, err := hurler.OpenSource(args[0], source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	profile, err := hurler.ProfileSource(src, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %s", args[0], err)
+	}
+	return write(os.Stdout, profile)
+}
+
+// maxShown is the most characters of a value shown in a table.
+const maxShown = 30
+
+// shown prepares a value for a table cell: control characters such as
+// newlines are escaped and long values cut.
+func shown(value string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range value {
+		if n == maxShown {
+			b.WriteString("…")
+			break
+		}
+		if r < ' ' || r == 0x7f {
+			q := fmt.Sprintf("%q", string(r))
+			b.WriteString(q[1 : len(q)-1])
+		} else {
+			b.WriteRune(r)
+		}
+		n++
+	}
+	return b.String()
+}
+
+func distinct(c *hurler.ColumnProfile) string {
+	if c.DistinctExact {
+		return fmt.Sprint(c.Distinct)
+	}
+	return fmt.Sprintf("~%d", c.Distinct)
+}
+
+func lengths(c *hurler.ColumnProfile) string {
+	if c.MinLength == c.MaxLength {
+		return fmt.Sprint(c.MinLength)
+	}
+	return fmt.Sprintf("%d-%d", c.MinLength, c.MaxLength)
+}
+
+func summary(p *hurler.Profile) string {
+	s := fmt.Sprintf("%d records", p.Records)
+	if p.BadRecords > 0 {
+		s += fmt.Sprintf(", %d that failed to parse or convert skipped", p.BadRecords)
+	}
+	return s
+}
+
+func writeProfileTable(out io.Writer, p *hurler.Profile) error {
+	fmt.Fprintf(out, "%s: %s\n\n", p.Path, summary(p))
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COLUMN\tTYPE\tNULLS\tEMPTY\tDISTINCT\tLENGTH\tMIN\tMAX")
+	for _, c := range p.Columns {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\n",
+			c.Name, c.Type, c.Nulls, c.Empty, distinct(c), lengths(c), shown(c.Min), shown(c.Max))
+	}
+	w.Flush()
+
+	for _, c := range p.Columns {
+		if len(c.Top) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "\n%s\n", c.Name)
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  VALUE\tCOUNT\tPATTERN\tCOUNT\tEXAMPLE")
+		for i := 0; i < len(c.Top) || i < len(c.Patterns); i++ {
+			var value, pattern string
+			if i < len(c.Top) {
+				value = fmt.Sprintf("%s\t%d", shown(c.Top[i].Value), c.Top[i].Count)
+			} else {
+				value = "\t"
+			}
+			if i < len(c.Patterns) {
+				pc := c.Patterns[i]
+				pattern = fmt.Sprintf("%s\t%d\t%s", shown(pc.Pattern), pc.Count, shown(pc.Example))
+			}
+			fmt.Fprintf(w, "  %s\t%s\n", value, pattern)
+		}
+		w.Flush()
+	}
+	return nil
+}
+
+func writeProfileJSON(out io.Writer, p *hurler.Profile) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// markdownCell escapes a value for a Markdown table cell or list, showing
+// it as code.
+func markdownCell(value string) string {
+	if value == "" {
+		return ""
+	}
+	value = strings.Replace(shown(value), "|", `\|`, -1)
+	fence := "`"
+	for strings.Contains(value, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(value, "`") || strings.HasSuffix(value, "`") {
+		return fence + " " + value + " " + fence
+	}
+	return fence + value + fence
+}
+
+func writeProfileMarkdown(out io.Writer, p *hurler.Profile) error {
+	fmt.Fprintf(out, "# Profile of %s\n\n%s.\n\n", p.Path, summary(p))
+	fmt.Fprintln(out, "| Column | Type | Nulls | Empty | Distinct | Length | Min | Max |")
+	fmt.Fprintln(out, "|---|---|--:|--:|--:|--:|---|---|")
+	for _, c := range p.Columns {
+		fmt.Fprintf(out, "| %s | %s | %d | %d | %s | %s | %s | %s |\n",
+			markdownCell(c.Name), c.Type, c.Nulls, c.Empty, distinct(c), lengths(c), markdownCell(c.Min), markdownCell(c.Max))
+	}
+
+	for _, c := range p.Columns {
+		if len(c.Top) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "\n## %s\n\n", markdownCell(c.Name))
+		fmt.Fprintln(out, "| Value | Count | Pattern | Count | Example |")
+		fmt.Fprintln(out, "|---|--:|---|--:|---|")
+		for i := 0; i < len(c.Top) || i < len(c.Patterns); i++ {
+			var value, valueCount, pattern, patternCount, example string
+			if i < len(c.Top) {
+				value, valueCount = markdownCell(c.Top[i].Value), fmt.Sprint(c.Top[i].Count)
+			}
+			if i < len(c.Patterns) {
+				pc := c.Patterns[i]
+				pattern, patternCount, example = markdownCell(pc.Pattern), fmt.Sprint(pc.Count), markdownCell(pc.Example)
+			}
+			fmt.Fprintf(out, "| %s | %s | %s | %s | %s |\n", value, valueCount, pattern, patternCount, example)
+		}
+	}
+	return nil
+}
@@ -27,7 +27,9 @@ var rootCmd = &cobra.Command{
 	Version: gitVersion + "\n" +
 		"built on " + buildTime + "\n" +
 		"from " + gitOrigin,
-	Short: "A brief description of your application",
+	SilenceErrors:     true, // Execute prints them
+	PersistentPreRunE: applyProfile,
+	Short:             "A brief description of your application",
 	Long: `A longer description that spans multiple lines and likely contains
 examples and usage of using your application. For example:
 
@@ -56,6 +58,17 @@ func init() {
 	// will be global for your application.
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.pghurler.yaml)")
+	rootCmd.PersistentFlags().String("profile", "", "profile of the config file to use")
+	viper.BindPFlag("profile", rootCmd.PersistentFlags().Lookup("profile"))
+	rootCmd.PersistentFlags().String("dsn", "", "connection string, as a URL or keyword/value pairs")
+	viper.BindPFlag("dsn", rootCmd.PersistentFlags().Lookup("dsn"))
+	rootCmd.PersistentFlags().String("sslmode", "", "TLS mode: disable, allow, prefer, require, verify-ca or verify-full")
+	rootCmd.PersistentFlags().String("sslrootcert", "", "file of the CA certificates the server's certificate is verified against")
+	rootCmd.PersistentFlags().String("sslcert", "", "file of the client certificate")
+	rootCmd.PersistentFlags().String("sslkey", "", "file of the client certificate's key")
+	for _, key := range sslSettings {
+		viper.BindPFlag(key, rootCmd.PersistentFlags().Lookup(key))
+	}
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -80,7 +93,10 @@ func initConfig() {
 		viper.SetConfigName(".pghurler")
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
+	// Read in environment variables that match, such as PGHURLER_DSN for
+	// dsn, the prefix keeping out generic names like DSN or PROFILE.
+	viper.SetEnvPrefix("PGHURLER")
+	viper.AutomaticEnv()
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
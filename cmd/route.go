@@ -0,0 +1,153 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/raginjason/pghurler/hurler"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"strings"
+)
+
+// routeConfig is an entry of the "routes" section of the config file. Its
+// options, when set, override the defaults of the command's flags for the
+// files it routes, but not flags given on the command line.
+type routeConfig struct {
+	Pattern         string
+	Table           string
+	Mode            string
+	Key             []string
+	OnlyChanged     *bool  `mapstructure:"only_changed"` // nil when not set
+	ReplaceStrategy string `mapstructure:"replace_strategy"`
+	Deletes         string
+	EffectiveColumn string `mapstructure:"effective_column"`
+	Delimiter       string
+}
+
+// feedConfig is an entry of the "feeds" section of the config file: a route
+// chosen by name with --feed rather than by file name, which may name the
+// profile it is loaded with. Without a pattern it takes any file.
+type feedConfig struct {
+	Profile string
+	Route   routeConfig `mapstructure:",squash"`
+}
+
+// configRoutes reads the "routes" section of the config file.
+func configRoutes() ([]routeConfig, error) {
+	var configs []routeConfig
+	if err := viper.UnmarshalKey("routes", &configs); err != nil {
+		return nil, fmt.Errorf("routes: %s", err)
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no --table given and no routes in the config file")
+	}
+	return configs, nil
+}
+
+// selectedFeed returns the feed named by the command's --feed flag, or nil
+// if none is.
+func selectedFeed(cmd *cobra.Command) (*feedConfig, error) {
+	flag := cmd.Flags().Lookup("feed")
+	if flag == nil || flag.Value.String() == "" {
+		return nil, nil
+	}
+	name := flag.Value.String()
+
+	var feeds map[string]feedConfig
+	if err := viper.UnmarshalKey("feeds", &feeds); err != nil {
+		return nil, fmt.Errorf("feeds: %s", err)
+	}
+	feed, ok := feeds[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("no feed '%s' in the config file", name)
+	}
+	if feed.Route.Table == "" {
+		return nil, fmt.Errorf("feed '%s' has no table", name)
+	}
+	return &feed, nil
+}
+
+// fileJobs makes a job of each file: loading it into load.Table if set,
+// else as the command's --feed, else as routed by the config file.
+func fileJobs(cmd *cobra.Command, paths []string, source hurler.SourceOptions, load hurler.LoadOptions) ([]hurler.Job, error) {
+	feed, err := selectedFeed(cmd)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case feed != nil:
+		if load.Table != "" {
+			return nil, fmt.Errorf("--table cannot be combined with --feed")
+		}
+		name, _ := cmd.Flags().GetString("feed")
+		jobs, err := routeJobs(cmd, paths, []routeConfig{feed.Route}, source, load)
+		if err != nil {
+			return nil, fmt.Errorf("feed %s: %s", name, err)
+		}
+		return jobs, nil
+	case load.Table != "":
+		var jobs []hurler.Job
+		for _, path := range paths {
+			jobs = append(jobs, hurler.Job{Path: path, Source: source, Load: load})
+		}
+		return jobs, nil
+	}
+	configs, err := configRoutes()
+	if err != nil {
+		return nil, err
+	}
+	return routeJobs(cmd, paths, configs, source, load)
+}
+
+// routeJobs makes a job of each file, sending it to the table of the first
+// of configs matching its name. Every file must be routed.
+func routeJobs(cmd *cobra.Command, paths []string, configs []routeConfig, source hurler.SourceOptions, load hurler.LoadOptions) ([]hurler.Job, error) {
+	routes := make([]hurler.Route, len(configs))
+	for i, c := range configs {
+		routes[i] = hurler.Route{Pattern: c.Pattern, Table: c.Table}
+	}
+	router, err := hurler.NewRouter(routes)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]hurler.Job, len(paths))
+	for i, path := range paths {
+		index, table := router.Match(path)
+		if index < 0 {
+			return nil, fmt.Errorf("no route matches %s", path)
+		}
+		c := configs[index]
+
+		job := hurler.Job{Path: path, Source: source, Load: load}
+		job.Load.Table = table
+		if c.Mode != "" && !givenFlag(cmd, "mode") {
+			job.Load.Mode = c.Mode
+		}
+		if len(c.Key) > 0 && !givenFlag(cmd, "key") {
+			job.Load.Key = c.Key
+		}
+		if c.OnlyChanged != nil && !givenFlag(cmd, "only-changed") {
+			job.Load.OnlyChanged = *c.OnlyChanged
+		}
+		if c.ReplaceStrategy != "" && !givenFlag(cmd, "replace-strategy") {
+			job.Load.Replace = c.ReplaceStrategy
+		}
+		if c.Deletes != "" && !givenFlag(cmd, "deletes") {
+			job.Load.Deletes = c.Deletes
+		}
+		if c.EffectiveColumn != "" && !givenFlag(cmd, "effective-column") {
+			job.Load.Effective = c.EffectiveColumn
+		}
+		if c.Delimiter != "" && !givenFlag(cmd, "delimiter") {
+			if job.Source.Delimiter, err = parseDelimiter(c.Delimiter); err != nil {
+				return nil, fmt.Errorf("route %d: %s", index+1, err)
+			}
+		}
+		jobs[i] = job
+	}
+	return jobs, nil
+}
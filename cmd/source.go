@@ -0,0 +1,129 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/raginjason/pghurler/hurler"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spf13/viper"
+)
+
+// nullConfig is the "nulls" section of the config file. Per-column markers
+// are a list rather than a map so column names keep their case.
+type nullConfig struct {
+	Markers     []string
+	MatchQuoted bool `mapstructure:"match_quoted"`
+	Columns     []struct {
+		Column  string
+		Markers []string
+	}
+}
+
+// conversionConfig is an entry of the "conversions" section of the config
+// file.
+type conversionConfig struct {
+	Column            string
+	hurler.Conversion `mapstructure:",squash"`
+}
+
+// addSourceFlags adds the flags that control how a file is read.
+func addSourceFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("delimiter", "d", "", `field delimiter, e.g. "," or "\t" (default derived from the file extension)`)
+	cmd.Flags().StringArray("null", nil, `value read as NULL, e.g. "" or "\N"; may be repeated`)
+	cmd.Flags().Bool("null-quoted", false, "apply NULL markers to quoted fields too")
+	cmd.Flags().StringArray("where", nil, "only load records matching this expression; may be repeated")
+	cmd.Flags().StringArray("skip", nil, "skip records matching this expression; may be repeated")
+	cmd.Flags().String("transforms", "", "YAML file of transforms, replacing those in the config file")
+}
+
+// sourceOptions gathers the options for opening a source from the command's
+// flags and the config file. Flags win over the config file.
+func sourceOptions(cmd *cobra.Command) (hurler.SourceOptions, error) {
+	opts := hurler.SourceOptions{LoadTime: time.Now()}
+	flags := cmd.Flags()
+
+	delimiter, _ := flags.GetString("delimiter")
+	if delimiter != "" {
+		d, err := parseDelimiter(delimiter)
+		if err != nil {
+			return opts, err
+		}
+		opts.Delimiter = d
+	}
+
+	var nulls nullConfig
+	if err := viper.UnmarshalKey("nulls", &nulls); err != nil {
+		return opts, fmt.Errorf("nulls: %s", err)
+	}
+	if flags.Changed("null") {
+		nulls.Markers, _ = flags.GetStringArray("null")
+	}
+	if flags.Changed("null-quoted") {
+		nulls.MatchQuoted, _ = flags.GetBool("null-quoted")
+	}
+	opts.Nulls = &hurler.NullRules{Markers: nulls.Markers, MatchQuoted: nulls.MatchQuoted}
+	if len(nulls.Columns) > 0 {
+		opts.Nulls.Columns = make(map[string][]string)
+		for _, c := range nulls.Columns {
+			opts.Nulls.Columns[c.Column] = c.Markers
+		}
+	}
+
+	var conversions []conversionConfig
+	if err := viper.UnmarshalKey("conversions", &conversions); err != nil {
+		return opts, fmt.Errorf("conversions: %s", err)
+	}
+	if len(conversions) > 0 {
+		opts.Conversions = make(map[string]hurler.Conversion)
+		for _, c := range conversions {
+			opts.Conversions[c.Column] = c.Conversion
+		}
+	}
+
+	if err := viper.UnmarshalKey("controls", &opts.Controls); err != nil {
+		return opts, fmt.Errorf("controls: %s", err)
+	}
+
+	if path, _ := flags.GetString("transforms"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return opts, err
+		}
+		defer f.Close()
+		if opts.Transforms, err = hurler.ReadTransforms(f); err != nil {
+			return opts, fmt.Errorf("%s: %s", path, err)
+		}
+	} else if err := viper.UnmarshalKey("transforms", &opts.Transforms); err != nil {
+		return opts, fmt.Errorf("transforms: %s", err)
+	}
+
+	opts.Where, _ = flags.GetStringArray("where")
+	opts.Skip, _ = flags.GetStringArray("skip")
+
+	return opts, nil
+}
+
+// parseDelimiter reads a delimiter given on the command line, which may be
+// written as an escape sequence or a name.
+func parseDelimiter(s string) (rune, error) {
+	switch s {
+	case `\t`, "tab":
+		return '\t', nil
+	case "pipe":
+		return '|', nil
+	case "comma":
+		return ',', nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if size != len(s) || r == utf8.RuneError {
+		return 0, fmt.Errorf("delimiter must be a single character, not '%s'", s)
+	}
+	return r, nil
+}
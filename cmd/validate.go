@@ -0,0 +1,113 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/raginjason/pghurler/hurler"
+	"github.com/spf13/cobra"
+)
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate <file|directory|glob>...",
+	Short: "Check that files would load into their tables, without loading them",
+	Long: `Check that files would load into their tables, without writing anything.
+
+Each file is read as load reads it, with the same NULL markers, control
+records, transforms, filters and conversions from the flags and the config
+file, and every problem that would fail its load is reported with its
+line: records that do not parse or convert, columns the table lacks, NOT
+NULL columns without a default the file lacks, NULLs in NOT NULL columns,
+values their column's type rejects, and control totals that do not match.
+
+The table's columns are read from the database, and values are checked by
+the server with the input functions of their types, as COPY checks them.
+The session is made read only. Constraints other than NOT NULL, such as
+unique or foreign keys, are not checked.
+
+The table is --table, else that of the --feed, else routed by file name
+as with load. The command fails if any file has a problem, so it can gate
+a load.
+
+` + connectionHelp,
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	RunE:         runValidate,
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().StringP("table", "t", "", "target table, optionally schema-qualified (default routed by file name)")
+	validateCmd.Flags().String("feed", "", "validate the files as the feed of this name in the config file")
+	validateCmd.Flags().Int("max-problems", 100, "stop a file after this many problems; 0 for no limit")
+	addSourceFlags(validateCmd)
+}
+
+func runValidate(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	source, err := sourceOptions(cmd)
+	if err != nil {
+		return err
+	}
+	var load hurler.LoadOptions
+	load.Table, _ = cmd.Flags().GetString("table")
+	var opts hurler.ValidateOptions
+	opts.MaxProblems, _ = cmd.Flags().GetInt("max-problems")
+
+	paths, err := hurler.ExpandPaths(args)
+	if err != nil {
+		return err
+	}
+	jobs, err := fileJobs(cmd, paths, source, load)
+	if err != nil {
+		return err
+	}
+
+	conn, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+
+	failed := 0
+	for _, job := range jobs {
+		v, err := validateFile(ctx, conn, job, opts)
+		if err != nil {
+			return fmt.Errorf("%s: %s", job.Path, err)
+		}
+
+		status := "ok"
+		if len(v.Problems) > 0 {
+			failed++
+			status = fmt.Sprintf("%d problems", len(v.Problems))
+			if v.Stopped {
+				status = fmt.Sprintf("stopped after %d problems", len(v.Problems))
+			}
+		}
+		fmt.Printf("%s: %d records for %s, %s\n", job.Path, v.Records, job.Load.Table, status)
+		for _, p := range v.Problems {
+			fmt.Printf("  %s\n", p)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files have problems", failed, len(jobs))
+	}
+	return nil
+}
+
+func validateFile(ctx context.Context, conn *pgx.Conn, job hurler.Job, opts hurler.ValidateOptions) (*hurler.Validation, error) {
+	src, err := hurler.OpenSource(job.Path, job.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	return hurler.ValidateSource(ctx, conn, src, job.Load.Table, opts)
+}
@@ -0,0 +1,327 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job is a file to load, with the options to read and load it with.
+type Job struct {
+	Path   string
+	Source SourceOptions
+	Load   LoadOptions
+}
+
+// JobResult is the outcome of a Job.
+type JobResult struct {
+	Job
+	Result   *LoadResult // nil unless the file was loaded
+	Err      error
+	Duration time.Duration
+	Attempts int // times the load was tried
+}
+
+// BatchOptions describe how a batch of jobs is loaded.
+type BatchOptions struct {
+	// Jobs is the number of files loaded at once, each over its own
+	// connection. Zero or one loads them one after the other.
+	Jobs int
+
+	// SingleTransaction loads every file in one transaction, over one
+	// connection, so that either all of them are loaded or none.
+	SingleTransaction bool
+
+	// Connect opens a connection to the database.
+	Connect func(context.Context) (*pgx.Conn, error)
+
+	// Retry says how loads failing on a transient error are retried,
+	// over a new connection if the failure closed theirs. A single
+	// transaction batch is retried as a whole.
+	Retry RetryPolicy
+}
+
+// errRolledBack is the error of the jobs of a single transaction batch that
+// were undone by another job's failure.
+var errRolledBack = errors.New("rolled back with the rest of the batch")
+
+// errNotRun is the error of the jobs of a single transaction batch left
+// after a job failed.
+var errNotRun = errors.New("not loaded, as an earlier file failed")
+
+// LoadBatch loads every job and returns their results in the same order. A
+// job's failure does not stop the others unless opts.SingleTransaction is
+// set.
+func LoadBatch(ctx context.Context, jobs []Job, opts BatchOptions) []JobResult {
+	results := make([]JobResult, len(jobs))
+	for i, job := range jobs {
+		results[i].Job = job
+	}
+	if opts.SingleTransaction {
+		loadSingleTx(ctx, results, opts)
+		return results
+	}
+
+	workers := opts.Jobs
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+
+	next := make(chan *JobResult)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := &batchConn{connect: opts.Connect}
+			for r := range next {
+				start := time.Now()
+				r.Result, r.Attempts, r.Err = loadRetrying(ctx, conn, r.Job, opts.Retry)
+				r.Duration = time.Since(start)
+			}
+			conn.close(ctx)
+		}()
+	}
+	for i := range results {
+		next <- &results[i]
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+// batchConn is the connection of a batch worker, opened when first needed
+// and again after a failure closes it.
+type batchConn struct {
+	connect func(context.Context) (*pgx.Conn, error)
+	conn    *pgx.Conn
+	err     error // why connecting failed for good
+}
+
+func (c *batchConn) get(ctx context.Context) (*pgx.Conn, error) {
+	if c.conn != nil && c.conn.IsClosed() {
+		c.conn = nil
+	}
+	if c.conn == nil && c.err == nil {
+		conn, err := c.connect(ctx)
+		if err != nil {
+			if !retryable(ctx, err, nil) {
+				c.err = err
+			}
+			return nil, err
+		}
+		c.conn = conn
+	}
+	return c.conn, c.err
+}
+
+func (c *batchConn) close(ctx context.Context) {
+	if c.conn != nil {
+		c.conn.Close(ctx)
+	}
+}
+
+// loadRetrying loads job, trying again while it fails on a transient error
+// and policy allows. A retry goes on with the load where it stopped rather
+// than starting another. It returns the number of attempts made.
+func loadRetrying(ctx context.Context, conn *batchConn, job Job, policy RetryPolicy) (*LoadResult, int, error) {
+	var entry *manifestEntry
+	for attempt := 1; ; attempt++ {
+		c, err := conn.get(ctx)
+		var res *LoadResult
+		if err == nil {
+			res, entry, err = loadFile(ctx, c, job, entry)
+		}
+		if err == nil || attempt >= policy.Attempts || !retryable(ctx, err, c) {
+			return res, attempt, err
+		}
+		if policy.wait(ctx, attempt) != nil {
+			return nil, attempt, err
+		}
+	}
+}
+
+func loadFile(ctx context.Context, conn *pgx.Conn, job Job, entry *manifestEntry) (*LoadResult, *manifestEntry, error) {
+	src, err := OpenSource(job.Path, job.Source)
+	if err != nil {
+		return nil, entry, err
+	}
+	defer src.Close()
+	return loadAttempt(ctx, conn, src, job.Load, entry)
+}
+
+// loadSingleTx loads every job in one transaction. The manifest rows of its
+// loads are written in the transaction too, so only the failure that
+// rolled it back is left recorded.
+func loadSingleTx(ctx context.Context, results []JobResult, opts BatchOptions) {
+	for attempt := 1; ; attempt++ {
+		for i := range results {
+			results[i].Result, results[i].Err, results[i].Attempts = nil, nil, attempt
+		}
+		if !singleTxAttempt(ctx, results, opts) || attempt >= opts.Retry.Attempts {
+			return
+		}
+		if opts.Retry.wait(ctx, attempt) != nil {
+			return
+		}
+	}
+}
+
+// singleTxAttempt makes one attempt at loading every job in one
+// transaction, and reports whether it failed in a way worth retrying.
+func singleTxAttempt(ctx context.Context, results []JobResult, opts BatchOptions) bool {
+	fail := func(from int, err error) {
+		for i := from; i < len(results); i++ {
+			results[i].Err = err
+		}
+	}
+
+	conn, err := opts.Connect(ctx)
+	if err != nil {
+		fail(0, err)
+		return retryable(ctx, err, nil)
+	}
+	defer conn.Close(ctx)
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		fail(0, err)
+		return retryable(ctx, err, conn)
+	}
+	defer tx.Rollback(ctx)
+
+	for i := range results {
+		r := &results[i]
+		start := time.Now()
+		r.Result, r.Err = loadFileInTx(ctx, tx, r.Job)
+		r.Duration = time.Since(start)
+		if r.Err == nil {
+			continue
+		}
+
+		tx.Rollback(ctx)
+		for j := range results[:i] {
+			results[j].Result, results[j].Err = nil, errRolledBack
+		}
+		fail(i+1, errNotRun)
+		if entry, err := startManifest(ctx, conn, r.Path, r.Load); err == nil {
+			entry.fail(ctx, conn, r.Err)
+		}
+		return retryable(ctx, r.Err, conn)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		for i := range results {
+			results[i].Result, results[i].Err = nil, fmt.Errorf("committing the batch: %s", err)
+		}
+		return retryable(ctx, err, conn)
+	}
+	return false
+}
+
+// loadFileInTx is Load within the transaction of a batch.
+func loadFileInTx(ctx context.Context, tx pgx.Tx, job Job) (*LoadResult, error) {
+	if job.Load.Workers > 1 {
+		return nil, fmt.Errorf("a file cannot be loaded over several workers in a single transaction batch")
+	}
+	if job.Load.CommitEvery > 0 {
+		return nil, fmt.Errorf("a file cannot be committed in parts in a single transaction batch")
+	}
+	src, err := OpenSource(job.Path, job.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	load, err := loadFor(src, job.Load)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := startManifest(ctx, tx.Conn(), src.Path, job.Load)
+	if err != nil {
+		return nil, err
+	}
+	if len(job.Load.Lineage) > 0 {
+		if err := src.addLineage(job.Load.Lineage, entry.id); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := loadInTx(ctx, tx, src, job.Load, entry, load)
+	if err != nil {
+		return nil, err
+	}
+	res.LoadID = entry.id
+	res.Skipped = src.FilterStats()
+	return res, nil
+}
+
+// ExpandPaths turns file, directory and glob arguments into the files they
+// name. A directory stands for the regular files directly in it. As in a
+// shell, hidden files are left out unless named or matched by a pattern
+// starting with a dot. Files are listed once, in the order found.
+func ExpandPaths(args []string) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	for _, arg := range args {
+		matches := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(arg); err != nil {
+				return nil, fmt.Errorf("%s: %s", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %s", arg)
+			}
+		}
+
+		for _, match := range matches {
+			if match != arg && isHidden(match) && !isHidden(arg) {
+				continue
+			}
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(match)
+				continue
+			}
+			entries, err := ioutil.ReadDir(match)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if e.Mode().IsRegular() && !isHidden(e.Name()) {
+					add(filepath.Join(match, e.Name()))
+				}
+			}
+		}
+	}
+	return paths, nil
+}
+
+func isHidden(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), ".")
+}
@@ -0,0 +1,118 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"context"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jackc/pgx/v4"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pghurler")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir) // clean up
+
+	for _, name := range []string{"b.csv", "a.csv.gz", ".hidden.csv", "sub/c.csv", "notes.txt"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %s", err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("failed to write file: %s", err)
+		}
+	}
+	in := func(names ...string) []string {
+		paths := make([]string, len(names))
+		for i, name := range names {
+			paths[i] = filepath.Join(dir, name)
+		}
+		return paths
+	}
+
+	tests := map[string]struct {
+		args []string
+		want []string
+		err  string
+	}{
+		"file":        {in("b.csv"), in("b.csv"), ""},
+		"directory":   {in(""), in("a.csv.gz", "b.csv", "notes.txt"), ""},
+		"glob":        {in("*.csv*"), in("a.csv.gz", "b.csv"), ""},
+		"glob of dir": {in("s*"), in("sub/c.csv"), ""},
+		"duplicates":  {in("b.csv", "*.csv"), in("b.csv"), ""},
+		"no match":    {in("*.tsv"), nil, "no files match " + filepath.Join(dir, "*.tsv")},
+		"missing":     {in("x.csv"), nil, "stat " + filepath.Join(dir, "x.csv") + ": no such file or directory"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ExpandPaths(tc.args)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Errorf("ExpandPaths(%q) = %v, want error %q", tc.args, err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExpandPaths(%q) failed: %s", tc.args, err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("ExpandPaths(%q) mismatch (-want +got):\n%s", tc.args, diff)
+			}
+		})
+	}
+}
+
+func TestLoadBatchSingleTxKeyed(t *testing.T) {
+	ctx := context.Background()
+	conn := testConn(t)
+	defer conn.Close(ctx)
+
+	_, err := conn.Exec(ctx, `CREATE TABLE pghurler_test_upsert (id int PRIMARY KEY, name text);
+		CREATE TABLE pghurler_test_scd2 (id int, name text, valid_from timestamptz, valid_to timestamptz, is_current boolean)`)
+	if err != nil {
+		t.Fatalf("failed to create tables: %s", err)
+	}
+	defer conn.Exec(ctx, "DROP TABLE pghurler_test_upsert, pghurler_test_scd2") // clean up
+
+	first := testFile(t, "*.csv", "id,name\n1,ada\n2,grace\n")
+	defer os.Remove(first) // clean up
+	second := testFile(t, "*.csv", "id,name\n2,hopper\n3,alan\n")
+	defer os.Remove(second) // clean up
+
+	var jobs []Job
+	for _, load := range []LoadOptions{
+		{Table: "pghurler_test_upsert", Mode: ModeUpsert, Key: []string{"id"}},
+		{Table: "pghurler_test_scd2", Mode: ModeSCD2, Key: []string{"id"}},
+	} {
+		jobs = append(jobs, Job{Path: first, Load: load}, Job{Path: second, Load: load})
+	}
+	connect := func(ctx context.Context) (*pgx.Conn, error) {
+		return pgx.Connect(ctx, os.Getenv("PGHURLER_TEST_DSN"))
+	}
+
+	for _, r := range LoadBatch(ctx, jobs, BatchOptions{SingleTransaction: true, Connect: connect}) {
+		if r.Err != nil {
+			t.Fatalf("loading %s into %s failed: %s", r.Path, r.Load.Table, r.Err)
+		}
+	}
+
+	var upserted, current int
+	if err := conn.QueryRow(ctx, "SELECT count(*) FROM pghurler_test_upsert").Scan(&upserted); err != nil {
+		t.Fatalf("counting rows failed: %s", err)
+	}
+	if err := conn.QueryRow(ctx, "SELECT count(*) FROM pghurler_test_scd2 WHERE is_current").Scan(&current); err != nil {
+		t.Fatalf("counting rows failed: %s", err)
+	}
+	if upserted != 3 || current != 3 {
+		t.Errorf("loaded %d upserted rows and %d current versions, want 3 of each", upserted, current)
+	}
+}
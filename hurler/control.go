@@ -0,0 +1,263 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ControlSpec describes a header or trailer record that carries control
+// totals for the file, such as the trailer TRL|000123|4567.89. Such records
+// are recognized by Pattern, kept out of the Reader's output, and their
+// totals checked against the records of the file, including those later
+// filtered out.
+type ControlSpec struct {
+	// Kind is "header" or "trailer". Header records may appear before the
+	// column names.
+	Kind string
+
+	// Pattern is a regular expression matched against the whole record,
+	// with its fields joined by the file's delimiter.
+	Pattern string
+
+	// Count is the 1-based field holding the declared number of records,
+	// or 0 if the record declares none.
+	Count int
+
+	// Sums are fields holding the declared total of a column.
+	Sums []ControlSum
+
+	// Required makes it an error for a file to have no such record.
+	Required bool
+}
+
+// ControlSum ties a 1-based field of a control record to the column whose
+// values it totals.
+type ControlSum struct {
+	Field  int
+	Column string
+}
+
+// ControlRecord is a header or trailer record found in the input.
+type ControlRecord struct {
+	Kind       string
+	LineNumber uint64
+	Fields     []string
+}
+
+// ControlError reports a control total that does not match the records of
+// the file.
+type ControlError struct {
+	Kind       string
+	LineNumber uint64
+	What       string
+	Declared   string
+	Actual     string
+}
+
+func (e *ControlError) Error() string {
+	return fmt.Sprintf("%s on line %d declares %s %s, but the records give %s", e.Kind, e.LineNumber, e.What, e.Declared, e.Actual)
+}
+
+// Controls recognizes control records and tallies the file's records to
+// check them against. Its methods may be called concurrently.
+type Controls struct {
+	mu        sync.Mutex
+	specs     []ControlSpec
+	patterns  []*regexp.Regexp
+	found     [][]*ControlRecord // records found, by spec
+	converter *Converter         // reads summed values, if set
+
+	count uint64
+	sums  map[string]*big.Rat
+}
+
+func NewControls(specs []ControlSpec) (*Controls, error) {
+	c := &Controls{specs: specs, found: make([][]*ControlRecord, len(specs)), sums: make(map[string]*big.Rat)}
+	for i, spec := range specs {
+		if spec.Kind != "header" && spec.Kind != "trailer" {
+			return nil, fmt.Errorf("control record %d: kind must be header or trailer, not '%s'", i+1, spec.Kind)
+		}
+		re, err := regexp.Compile(spec.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("control record %d: %s", i+1, err)
+		}
+		if spec.Count < 0 {
+			return nil, fmt.Errorf("control record %d: count field must not be negative", i+1)
+		}
+		for _, sum := range spec.Sums {
+			if sum.Field < 1 || sum.Column == "" {
+				return nil, fmt.Errorf("control record %d: sums need a field and a column", i+1)
+			}
+			c.sums[sum.Column] = new(big.Rat)
+		}
+		c.patterns = append(c.patterns, re)
+	}
+	return c, nil
+}
+
+// match reports whether raw is a control record, remembering it if so.
+// Only header specs are considered when headerOnly is set.
+func (c *Controls) match(raw *rawRecord, comma rune, headerOnly bool) bool {
+	i := c.matches(strings.Join(raw.fields, string(comma)), headerOnly)
+	if i < 0 {
+		return false
+	}
+	c.mu.Lock()
+	c.found[i] = append(c.found[i], &ControlRecord{Kind: c.specs[i].Kind, LineNumber: raw.line, Fields: raw.fields})
+	c.mu.Unlock()
+	return true
+}
+
+// matches returns the index of the first spec matching a record's text, or
+// -1 if it is no control record.
+func (c *Controls) matches(text string, headerOnly bool) int {
+	for i, spec := range c.specs {
+		if headerOnly && spec.Kind != "header" {
+			continue
+		}
+		if c.patterns[i].MatchString(text) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Found returns the control records recognized so far.
+func (c *Controls) Found() []*ControlRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var all []*ControlRecord
+	for _, recs := range c.found {
+		all = append(all, recs...)
+	}
+	return all
+}
+
+// Tally counts rec and adds its values to the column sums. Values of columns
+// with a conversion are summed once converted, so formatted numbers add up.
+func (c *Controls) Tally(rec *Record) error {
+	values := make(map[string]*big.Rat, len(c.sums))
+	for col := range c.sums {
+		if rec.IsNull(col) {
+			continue
+		}
+		value := rec.Values[col]
+		if c.converter != nil {
+			var err error
+			if value, err = c.converter.value(rec, col); err != nil {
+				return err
+			}
+		}
+		v, ok := new(big.Rat).SetString(strings.TrimSpace(value))
+		if !ok {
+			return &ConversionError{Column: col, LineNumber: rec.LineNumber, Value: rec.Values[col], Type: "numeric", Err: fmt.Errorf("cannot be summed for control total")}
+		}
+		values[col] = v
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count++
+	for col, v := range values {
+		c.sums[col].Add(c.sums[col], v)
+	}
+	return nil
+}
+
+// Validate checks every control record found against the tallied records.
+func (c *Controls) Validate() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, spec := range c.specs {
+		if spec.Required && len(c.found[i]) == 0 {
+			return fmt.Errorf("no %s record matching '%s' found", spec.Kind, spec.Pattern)
+		}
+		for _, rec := range c.found[i] {
+			if err := c.validate(spec, rec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Controls) validate(spec ControlSpec, rec *ControlRecord) error {
+	field := func(n int) (string, error) {
+		if n > len(rec.Fields) {
+			return "", fmt.Errorf("%s on line %d has no field %d", rec.Kind, rec.LineNumber, n)
+		}
+		return strings.TrimSpace(rec.Fields[n-1]), nil
+	}
+
+	if spec.Count > 0 {
+		s, err := field(spec.Count)
+		if err != nil {
+			return err
+		}
+		declared, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			return fmt.Errorf("%s on line %d has record count '%s', which is not a number", rec.Kind, rec.LineNumber, s)
+		}
+		if !declared.IsUint64() || declared.Uint64() != c.count {
+			return &ControlError{Kind: rec.Kind, LineNumber: rec.LineNumber, What: "record count", Declared: declared.String(), Actual: fmt.Sprint(c.count)}
+		}
+	}
+
+	for _, sum := range spec.Sums {
+		s, err := field(sum.Field)
+		if err != nil {
+			return err
+		}
+		declared, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return fmt.Errorf("%s on line %d has total '%s' for %s, which is not a number", rec.Kind, rec.LineNumber, s, sum.Column)
+		}
+		actual := c.sums[sum.Column]
+		if declared.Cmp(actual) != 0 {
+			return &ControlError{Kind: rec.Kind, LineNumber: rec.LineNumber, What: sum.Column + " total", Declared: s, Actual: decimalString(actual)}
+		}
+	}
+
+	return nil
+}
+
+// decimalString formats r without trailing zeros, or as a fraction if it has
+// no finite decimal expansion.
+func decimalString(r *big.Rat) string {
+	for prec := 0; prec <= 20; prec++ {
+		s := r.FloatString(prec)
+		if v, _ := new(big.Rat).SetString(s); v.Cmp(r) == 0 {
+			return s
+		}
+	}
+	return r.RatString()
+}
+
+// TallyingReader tallies each record read from a RecordReader against
+// Controls.
+type TallyingReader struct {
+	source   RecordReader
+	controls *Controls
+}
+
+func NewTallyingReader(source RecordReader, controls *Controls) *TallyingReader {
+	return &TallyingReader{source: source, controls: controls}
+}
+
+func (r *TallyingReader) Read() (*Record, error) {
+	rec, err := r.source.Read()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.controls.Tally(rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
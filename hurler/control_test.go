@@ -0,0 +1,142 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"io"
+	"strings"
+	"testing"
+)
+
+const controlFile = `HDR|20200229|ACME
+id|amount
+1|10.25
+2|20.50
+3|-0.75
+TRL|000003|30.00
+`
+
+func TestControls(t *testing.T) {
+
+	trailer := ControlSpec{Kind: "trailer", Pattern: `^TRL\|`, Count: 2, Sums: []ControlSum{{Field: 3, Column: "amount"}}}
+
+	tests := map[string]struct {
+		input string
+		specs []ControlSpec
+		lines []uint64
+		err   error
+	}{
+		"header and trailer": {
+			controlFile,
+			[]ControlSpec{{Kind: "header", Pattern: `^HDR\|`}, trailer},
+			[]uint64{3, 4, 5},
+			nil,
+		},
+		"count mismatch": {
+			strings.Replace(controlFile, "000003", "000004", 1),
+			[]ControlSpec{{Kind: "header", Pattern: `^HDR\|`}, trailer},
+			[]uint64{3, 4, 5},
+			&ControlError{Kind: "trailer", LineNumber: 6, What: "record count", Declared: "4", Actual: "3"},
+		},
+		"sum mismatch": {
+			strings.Replace(controlFile, "30.00", "30.75", 1),
+			[]ControlSpec{{Kind: "header", Pattern: `^HDR\|`}, trailer},
+			[]uint64{3, 4, 5},
+			&ControlError{Kind: "trailer", LineNumber: 6, What: "amount total", Declared: "30.75", Actual: "30"},
+		},
+		"required trailer missing": {
+			"id|amount\n1|10.25\n",
+			[]ControlSpec{{Kind: "trailer", Pattern: `^TRL\|`, Count: 2, Required: true}},
+			[]uint64{2},
+			errors.New(`no trailer record matching '^TRL\|' found`),
+		},
+		"missing field": {
+			"id|amount\n1|10.25\nTRL\n",
+			[]ControlSpec{{Kind: "trailer", Pattern: `^TRL`, Count: 2}},
+			[]uint64{2},
+			errors.New("trailer on line 3 has no field 2"),
+		},
+	}
+
+	equateErrorMessage := cmp.Comparer(func(x, y error) bool {
+		if x == nil || y == nil {
+			return x == nil && y == nil
+		}
+		return x.Error() == y.Error()
+	})
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			controls, err := NewControls(tc.specs)
+			if err != nil {
+				t.Fatalf("NewControls() failed: %s", err)
+			}
+
+			r, err := NewDelimitedReader(strings.NewReader(tc.input), '|')
+			if err != nil {
+				t.Fatalf("NewDelimitedReader() failed: %s", err)
+			}
+			if err := r.SetControls(controls); err != nil {
+				t.Fatalf("SetControls() failed: %s", err)
+			}
+
+			if diff := cmp.Diff([]string{"id", "amount"}, r.Columns()); diff != "" {
+				t.Errorf("Columns() mismatch (-want +got):\n%s", diff)
+			}
+
+			tr := NewTallyingReader(r, controls)
+			var lines []uint64
+			for {
+				rec, err := tr.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Read() failed: %s", err)
+				}
+				lines = append(lines, rec.LineNumber)
+			}
+
+			if diff := cmp.Diff(tc.lines, lines); diff != "" {
+				t.Errorf("record lines mismatch (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.err, controls.Validate(), equateErrorMessage); diff != "" {
+				t.Errorf("Error mismatch for Validate() (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewControlsErrors(t *testing.T) {
+
+	tests := map[string]struct {
+		spec ControlSpec
+		err  error
+	}{
+		"bad kind":    {ControlSpec{Kind: "footer", Pattern: "x"}, errors.New("control record 1: kind must be header or trailer, not 'footer'")},
+		"bad pattern": {ControlSpec{Kind: "trailer", Pattern: "("}, errors.New("control record 1: error parsing regexp: missing closing ): `(`")},
+		"bad sum":     {ControlSpec{Kind: "trailer", Pattern: "x", Sums: []ControlSum{{Column: "amount"}}}, errors.New("control record 1: sums need a field and a column")},
+	}
+
+	equateErrorMessage := cmp.Comparer(func(x, y error) bool {
+		if x == nil || y == nil {
+			return x == nil && y == nil
+		}
+		return x.Error() == y.Error()
+	})
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewControls([]ControlSpec{tc.spec})
+
+			if diff := cmp.Diff(tc.err, err, equateErrorMessage); diff != "" {
+				t.Errorf("Error mismatch for NewControls() (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,438 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Conversion describes how the text of one column is parsed into its target
+// type. Converted values are rewritten in the canonical text form PostgreSQL
+// accepts for that type, so they can be streamed to COPY unchanged.
+type Conversion struct {
+	// Type is the target type: text, integer, numeric, boolean, date,
+	// timestamp or timestamptz. Common PostgreSQL aliases such as bigint,
+	// decimal or bool are accepted too.
+	Type string
+
+	// Format is the layout of date and timestamp values, written with
+	// tokens like YYYY, MM, DD, HH24, MI and SS (e.g. "MM/DD/YYYY" or
+	// "YYYYMMDD"), as a Go time layout, or "excel" for spreadsheet serial
+	// day numbers. Empty means ISO 8601.
+	Format string
+
+	// Thousands and Decimal are the digit grouping and decimal separators
+	// of numbers. They default to "," and ".". Grouping must be in threes.
+	Thousands string
+	Decimal   string
+
+	// Currency lists symbols stripped from numbers, e.g. "$" or "EUR".
+	Currency []string
+
+	// True and False list the spellings of booleans, compared without
+	// regard to case. They default to true/t/yes/y/1 and false/f/no/n/0.
+	True  []string
+	False []string
+
+	// Timezone is the IANA zone of timestamps that do not carry their own
+	// offset. It defaults to UTC.
+	Timezone string
+}
+
+// ConversionError reports a value that could not be converted.
+type ConversionError struct {
+	Column     string
+	LineNumber uint64
+	Value      string
+	Type       string
+	Err        error
+}
+
+func (e *ConversionError) Error() string {
+	return fmt.Sprintf("line %d, column %q: cannot convert %q to %s: %s", e.LineNumber, e.Column, e.Value, e.Type, e.Err)
+}
+
+func (e *ConversionError) Unwrap() error {
+	return e.Err
+}
+
+// Converter applies Conversions to the columns of each record.
+type Converter struct {
+	columns map[string]*converter
+	order   []string
+}
+
+// converter is a Conversion prepared for repeated use.
+type converter struct {
+	kind     string
+	layouts  []string
+	excel    bool
+	location *time.Location
+	trues    map[string]bool
+	falses   map[string]bool
+
+	thousands, decimal string
+	currency           *strings.Replacer
+}
+
+var typeAliases = map[string]string{
+	"text":                        "text",
+	"varchar":                     "text",
+	"character varying":           "text",
+	"char":                        "text",
+	"character":                   "text",
+	"integer":                     "integer",
+	"int":                         "integer",
+	"int2":                        "integer",
+	"int4":                        "integer",
+	"int8":                        "integer",
+	"smallint":                    "integer",
+	"bigint":                      "integer",
+	"numeric":                     "numeric",
+	"decimal":                     "numeric",
+	"real":                        "numeric",
+	"float4":                      "numeric",
+	"float8":                      "numeric",
+	"double precision":            "numeric",
+	"money":                       "numeric",
+	"boolean":                     "boolean",
+	"bool":                        "boolean",
+	"date":                        "date",
+	"timestamp":                   "timestamp",
+	"timestamp without time zone": "timestamp",
+	"timestamptz":                 "timestamptz",
+	"timestamp with time zone":    "timestamptz",
+}
+
+var (
+	defaultTrue  = []string{"true", "t", "yes", "y", "1"}
+	defaultFalse = []string{"false", "f", "no", "n", "0"}
+
+	isoLayouts = []string{
+		"2006-01-02T15:04:05Z07:00",
+		"2006-01-02 15:04:05Z07:00",
+		"2006-01-02 15:04:05-07",
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05",
+		"2006-01-02",
+	}
+
+	integerPattern = regexp.MustCompile(`^[+-]?\d+$`)
+	numericPattern = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)([eE][+-]?\d+)?$`)
+)
+
+// formatTokens maps the tokens allowed in Conversion.Format to Go layout
+// elements. Longer tokens come first so they win over their prefixes.
+var formatTokens = []struct{ token, layout string }{
+	{"YYYY", "2006"},
+	{"YY", "06"},
+	{"MONTH", "January"},
+	{"MON", "Jan"},
+	{"MM", "01"},
+	{"DD", "02"},
+	{"HH24", "15"},
+	{"HH12", "03"},
+	{"HH", "15"},
+	{"MI", "04"},
+	{"SS", "05"},
+	{"AM", "PM"},
+	{"PM", "PM"},
+	{"TZ", "MST"},
+	{"OF", "-07:00"},
+}
+
+// excelEpoch is day zero of spreadsheet serial dates. It lies on 30 December
+// rather than 1 January to absorb the phantom 29 February 1900 that
+// spreadsheets count.
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// NewConverter prepares the conversions for the given columns.
+func NewConverter(columns map[string]Conversion) (*Converter, error) {
+	c := &Converter{columns: make(map[string]*converter)}
+	for col, conv := range columns {
+		cc, err := newConverter(conv)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %s", col, err)
+		}
+		c.columns[col] = cc
+		c.order = append(c.order, col)
+	}
+	sort.Strings(c.order)
+	return c, nil
+}
+
+func newConverter(conv Conversion) (*converter, error) {
+	kind, ok := typeAliases[strings.ToLower(strings.TrimSpace(conv.Type))]
+	if !ok {
+		return nil, fmt.Errorf("unknown type '%s'", conv.Type)
+	}
+
+	c := &converter{kind: kind, location: time.UTC}
+
+	if conv.Timezone != "" {
+		loc, err := time.LoadLocation(conv.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		c.location = loc
+	}
+
+	switch {
+	case strings.EqualFold(conv.Format, "excel"):
+		c.excel = true
+	case conv.Format != "":
+		c.layouts = []string{goLayout(conv.Format)}
+	default:
+		c.layouts = isoLayouts
+	}
+
+	thousands, decimal := conv.Thousands, conv.Decimal
+	if thousands == "" {
+		thousands = ","
+	}
+	if decimal == "" {
+		decimal = "."
+	}
+	if thousands == decimal {
+		return nil, fmt.Errorf("thousands and decimal separators are both '%s'", decimal)
+	}
+	c.thousands, c.decimal = thousands, decimal
+	var pairs []string
+	for _, sym := range conv.Currency {
+		pairs = append(pairs, sym, "")
+	}
+	c.currency = strings.NewReplacer(pairs...)
+
+	trues, falses := conv.True, conv.False
+	if trues == nil {
+		trues = defaultTrue
+	}
+	if falses == nil {
+		falses = defaultFalse
+	}
+	c.trues, c.falses = make(map[string]bool), make(map[string]bool)
+	for _, s := range trues {
+		c.trues[strings.ToLower(s)] = true
+	}
+	for _, s := range falses {
+		c.falses[strings.ToLower(s)] = true
+	}
+
+	return c, nil
+}
+
+// goLayout translates a Conversion.Format into a Go time layout. A format
+// that already is a Go layout is returned unchanged.
+func goLayout(format string) string {
+	if strings.Contains(format, "2006") {
+		return format
+	}
+
+	var b strings.Builder
+	upper := strings.ToUpper(format)
+outer:
+	for i := 0; i < len(format); {
+		for _, t := range formatTokens {
+			if strings.HasPrefix(upper[i:], t.token) {
+				b.WriteString(t.layout)
+				i += len(t.token)
+				continue outer
+			}
+		}
+		b.WriteByte(format[i])
+		i++
+	}
+	return b.String()
+}
+
+// Convert rewrites the values of the record's converted columns into their
+// canonical form. NULL values are left alone. The first failure is returned
+// as a *ConversionError.
+func (c *Converter) Convert(rec *Record) error {
+	for _, col := range c.order {
+		if rec.IsNull(col) {
+			continue
+		}
+		if _, ok := rec.Values[col]; !ok {
+			continue
+		}
+		out, err := c.value(rec, col)
+		if err != nil {
+			return err
+		}
+		rec.Values[col] = out
+	}
+	return nil
+}
+
+// value returns the value of col in rec converted, or as it is if col has no
+// conversion.
+func (c *Converter) value(rec *Record, col string) (string, error) {
+	value := rec.Values[col]
+	conv, ok := c.columns[col]
+	if !ok {
+		return value, nil
+	}
+	out, err := conv.convert(value)
+	if err != nil {
+		return "", &ConversionError{Column: col, LineNumber: rec.LineNumber, Value: value, Type: conv.kind, Err: err}
+	}
+	return out, nil
+}
+
+func (c *converter) convert(value string) (string, error) {
+	switch c.kind {
+	case "integer":
+		s, err := c.normalizeNumber(value)
+		if err != nil {
+			return "", err
+		}
+		if !integerPattern.MatchString(s) {
+			return "", fmt.Errorf("not an integer")
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return "", err.(*strconv.NumError).Err
+		}
+		return strconv.FormatInt(n, 10), nil
+	case "numeric":
+		s, err := c.normalizeNumber(value)
+		if err != nil {
+			return "", err
+		}
+		if !numericPattern.MatchString(s) {
+			return "", fmt.Errorf("not a number")
+		}
+		return s, nil
+	case "boolean":
+		s := strings.ToLower(strings.TrimSpace(value))
+		switch {
+		case c.trues[s]:
+			return "true", nil
+		case c.falses[s]:
+			return "false", nil
+		}
+		return "", fmt.Errorf("not a boolean")
+	case "date":
+		t, err := c.parseTime(value)
+		if err != nil {
+			return "", err
+		}
+		return t.Format("2006-01-02"), nil
+	case "timestamp":
+		t, err := c.parseTime(value)
+		if err != nil {
+			return "", err
+		}
+		return t.In(c.location).Format("2006-01-02 15:04:05.999999"), nil
+	case "timestamptz":
+		t, err := c.parseTime(value)
+		if err != nil {
+			return "", err
+		}
+		return t.Format("2006-01-02 15:04:05.999999-07:00"), nil
+	}
+	return value, nil
+}
+
+// normalizeNumber strips currency symbols, grouping and the whitespace around
+// a number and gives it a "." decimal point. Spaces within it are kept, so
+// "12 34" is rejected, unless space is the thousands separator. Grouping
+// must split the whole part into threes, so that with the default
+// separators "3,14" is rejected rather than read as 314. Accounting style
+// negatives such as "(1,234.50)" become "-1234.50".
+func (c *converter) normalizeNumber(value string) (string, error) {
+	s := strings.TrimSpace(value)
+	negative := strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")")
+	if negative {
+		s = s[1 : len(s)-1]
+	}
+	s = strings.TrimSpace(c.currency.Replace(s))
+
+	whole, fraction := s, ""
+	if i := strings.Index(s, c.decimal); i >= 0 {
+		whole, fraction = s[:i], "."+s[i+len(c.decimal):]
+	}
+	if strings.Contains(whole, c.thousands) {
+		groups := strings.Split(whole, c.thousands)
+		if !inThrees(groups) {
+			return "", fmt.Errorf("digits are not grouped in threes by '%s'", c.thousands)
+		}
+		whole = strings.Join(groups, "")
+	}
+	s = whole + fraction
+
+	if negative && !strings.HasPrefix(s, "-") {
+		s = "-" + s
+	}
+	return s, nil
+}
+
+// inThrees reports whether groups are those of digits grouped in threes: a
+// first of one to three digits after any sign, then of exactly three.
+func inThrees(groups []string) bool {
+	for i, g := range groups {
+		if i == 0 {
+			g = strings.TrimLeft(g, "+-")
+		}
+		if g == "" || len(g) > 3 || (i > 0 && len(g) != 3) {
+			return false
+		}
+		for _, r := range g {
+			if r < '0' || r > '9' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (c *converter) parseTime(value string) (time.Time, error) {
+	s := strings.TrimSpace(value)
+
+	if c.excel {
+		days, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("not a spreadsheet serial date")
+		}
+		whole, frac := math.Modf(days)
+		t := excelEpoch.AddDate(0, 0, int(whole)).Add(time.Duration(math.Round(frac*86400)) * time.Second)
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, c.location), nil
+	}
+
+	for _, layout := range c.layouts {
+		if t, err := time.ParseInLocation(layout, s, c.location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("does not match format")
+}
+
+// ConvertingReader converts each record read from a RecordReader.
+type ConvertingReader struct {
+	source    RecordReader
+	converter *Converter
+}
+
+func NewConvertingReader(source RecordReader, converter *Converter) *ConvertingReader {
+	return &ConvertingReader{source: source, converter: converter}
+}
+
+func (r *ConvertingReader) Read() (*Record, error) {
+	rec, err := r.source.Read()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.converter.Convert(rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestConverterConvert(t *testing.T) {
+
+	tests := map[string]struct {
+		conv  Conversion
+		value string
+		want  string
+		err   error
+	}{
+		"text":                   {Conversion{Type: "varchar"}, " as is ", " as is ", nil},
+		"integer":                {Conversion{Type: "bigint"}, "1,234,567", "1234567", nil},
+		"integer sign":           {Conversion{Type: "int"}, "-42", "-42", nil},
+		"integer fraction":       {Conversion{Type: "integer"}, "1.5", "", errors.New("not an integer")},
+		"integer overflow":       {Conversion{Type: "integer"}, "99999999999999999999", "", errors.New("value out of range")},
+		"numeric":                {Conversion{Type: "numeric"}, "1,234.50", "1234.50", nil},
+		"numeric comma decimal":  {Conversion{Type: "numeric", Thousands: ".", Decimal: ","}, "1.234,5", "1234.5", nil},
+		"numeric currency":       {Conversion{Type: "numeric", Currency: []string{"$"}}, "$ 12.00", "12.00", nil},
+		"numeric accounting":     {Conversion{Type: "numeric", Currency: []string{"€"}}, "(€1,000)", "-1000", nil},
+		"numeric garbage":        {Conversion{Type: "numeric"}, "12abc", "", errors.New("not a number")},
+		"numeric inner space":    {Conversion{Type: "numeric"}, " 12 34 ", "", errors.New("not a number")},
+		"numeric space grouping": {Conversion{Type: "numeric", Thousands: " ", Decimal: ","}, "1 234,5", "1234.5", nil},
+		"numeric comma fraction": {Conversion{Type: "numeric"}, "3,14", "", errors.New("digits are not grouped in threes by ','")},
+		"integer bad grouping":   {Conversion{Type: "integer"}, "12,34,567", "", errors.New("digits are not grouped in threes by ','")},
+		"numeric signed grouped": {Conversion{Type: "numeric"}, "-12,345.5", "-12345.5", nil},
+		"numeric trailing code":  {Conversion{Type: "numeric", Currency: []string{"EUR"}}, "1,234 EUR", "1234", nil},
+		"boolean yes":            {Conversion{Type: "bool"}, "Yes", "true", nil},
+		"boolean custom":         {Conversion{Type: "boolean", True: []string{"J"}, False: []string{"N"}}, "j", "true", nil},
+		"boolean garbage":        {Conversion{Type: "boolean"}, "maybe", "", errors.New("not a boolean")},
+		"date iso":               {Conversion{Type: "date"}, "2020-02-29", "2020-02-29", nil},
+		"date us":                {Conversion{Type: "date", Format: "MM/DD/YYYY"}, "02/29/2020", "2020-02-29", nil},
+		"date compact":           {Conversion{Type: "date", Format: "YYYYMMDD"}, "20200229", "2020-02-29", nil},
+		"date go layout":         {Conversion{Type: "date", Format: "02 Jan 2006"}, "29 Feb 2020", "2020-02-29", nil},
+		"date excel":             {Conversion{Type: "date", Format: "excel"}, "43890", "2020-02-29", nil},
+		"date invalid":           {Conversion{Type: "date", Format: "MM/DD/YYYY"}, "02/30/2020", "", errors.New("does not match format")},
+		"timestamp":              {Conversion{Type: "timestamp", Format: "DD.MM.YYYY HH24:MI"}, "29.02.2020 13:05", "2020-02-29 13:05:00", nil},
+		"timestamp excel":        {Conversion{Type: "timestamp", Format: "excel"}, "43890.75", "2020-02-29 18:00:00", nil},
+		"timestamptz default tz": {Conversion{Type: "timestamptz", Timezone: "America/New_York"}, "2020-02-29 13:05:00", "2020-02-29 13:05:00-05:00", nil},
+		"timestamptz own offset": {Conversion{Type: "timestamptz", Timezone: "America/New_York"}, "2020-02-29T13:05:00+01:00", "2020-02-29 13:05:00+01:00", nil},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			c, err := NewConverter(map[string]Conversion{"col": tc.conv})
+			if err != nil {
+				t.Fatalf("NewConverter() failed: %s", err)
+			}
+
+			rec := &Record{LineNumber: 7, RecordNumber: 6, Values: map[string]string{"col": tc.value}}
+			err = c.Convert(rec)
+
+			if tc.err != nil {
+				var convErr *ConversionError
+				if !errors.As(err, &convErr) {
+					t.Fatalf("Convert() error = %v, want *ConversionError", err)
+				}
+				if diff := cmp.Diff(tc.err.Error(), convErr.Err.Error()); diff != "" {
+					t.Errorf("Convert() error mismatch (-want +got):\n%s", diff)
+				}
+				if convErr.Column != "col" || convErr.LineNumber != 7 {
+					t.Errorf("Convert() error located at %q line %d, want \"col\" line 7", convErr.Column, convErr.LineNumber)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Convert() failed: %s", err)
+			}
+
+			if diff := cmp.Diff(tc.want, rec.Values["col"]); diff != "" {
+				t.Errorf("Convert(%q) mismatch (-want +got):\n%s", tc.value, diff)
+			}
+		})
+	}
+}
+
+func TestConverterSkipsNulls(t *testing.T) {
+	c, err := NewConverter(map[string]Conversion{"col": {Type: "integer"}})
+	if err != nil {
+		t.Fatalf("NewConverter() failed: %s", err)
+	}
+
+	rec := &Record{Values: map[string]string{"col": ""}}
+	rec.SetNull("col")
+
+	if err := c.Convert(rec); err != nil {
+		t.Errorf("Convert() of NULL failed: %s", err)
+	}
+}
+
+func TestNewConverterErrors(t *testing.T) {
+
+	tests := map[string]struct {
+		conv Conversion
+		err  error
+	}{
+		"unknown type":     {Conversion{Type: "blob"}, errors.New(`column "col": unknown type 'blob'`)},
+		"same separators":  {Conversion{Type: "numeric", Thousands: ".", Decimal: "."}, errors.New(`column "col": thousands and decimal separators are both '.'`)},
+		"unknown timezone": {Conversion{Type: "timestamptz", Timezone: "Mars/Olympus"}, errors.New(`column "col": unknown time zone Mars/Olympus`)},
+	}
+
+	equateErrorMessage := cmp.Comparer(func(x, y error) bool {
+		if x == nil || y == nil {
+			return x == nil && y == nil
+		}
+		return x.Error() == y.Error()
+	})
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewConverter(map[string]Conversion{"col": tc.conv})
+
+			if diff := cmp.Diff(tc.err, err, equateErrorMessage); diff != "" {
+				t.Errorf("Error mismatch for NewConverter() (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
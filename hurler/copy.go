@@ -0,0 +1,65 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"bytes"
+	"strings"
+)
+
+// copyEscaper escapes values for PostgreSQL's COPY text format.
+var copyEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+)
+
+// copyNull is how COPY text format spells NULL.
+const copyNull = `\N`
+
+// CopyReader streams Records in PostgreSQL's COPY text format, suitable for
+// COPY ... FROM STDIN. Columns are written in the given order and NULL values
+// as \N.
+type CopyReader struct {
+	source  RecordReader
+	columns []string
+	buf     bytes.Buffer
+	err     error
+}
+
+func NewCopyReader(source RecordReader, columns []string) *CopyReader {
+	return &CopyReader{source: source, columns: columns}
+}
+
+func (c *CopyReader) Read(p []byte) (int, error) {
+	for c.buf.Len() < len(p) && c.err == nil {
+		var rec *Record
+		rec, c.err = c.source.Read()
+		if c.err != nil {
+			break
+		}
+		writeCopyRecord(&c.buf, c.columns, rec)
+	}
+
+	if c.buf.Len() > 0 {
+		return c.buf.Read(p)
+	}
+	return 0, c.err
+}
+
+func writeCopyRecord(buf *bytes.Buffer, columns []string, rec *Record) {
+	for i, col := range columns {
+		if i > 0 {
+			buf.WriteByte('\t')
+		}
+		if rec.IsNull(col) {
+			buf.WriteString(copyNull)
+			continue
+		}
+		copyEscaper.WriteString(buf, rec.Values[col])
+	}
+	buf.WriteByte('\n')
+}
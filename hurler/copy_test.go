@@ -0,0 +1,61 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCopyReader(t *testing.T) {
+
+	tests := map[string]struct {
+		input   string
+		columns []string
+		want    string
+	}{
+		"zero records": {
+			headerString,
+			[]string{"col1", "col2"},
+			"",
+		},
+		"column order": {
+			headerDataString,
+			[]string{"col2", "col1"},
+			"val2\tval1\n",
+		},
+		"escaping": {
+			"col1,col2\n\"a\tb\",\"c\\d\ne\"",
+			[]string{"col1", "col2"},
+			"a\\tb\tc\\\\d\\ne\n",
+		},
+		"nulls": {
+			"col1,col2\n,\"\"\nNULL,x",
+			[]string{"col1", "col2"},
+			"\\N\t\n\\N\tx\n",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r, err := NewDelimitedReader(strings.NewReader(tc.input), ',')
+			if err != nil {
+				t.Fatalf("NewDelimitedReader() failed: %s", err)
+			}
+			r.NullRules = &NullRules{Markers: []string{"", "NULL"}}
+
+			got, err := ioutil.ReadAll(NewCopyReader(r, tc.columns))
+			if err != nil {
+				t.Fatalf("reading CopyReader failed: %s", err)
+			}
+
+			if diff := cmp.Diff(tc.want, string(got)); diff != "" {
+				t.Errorf("CopyReader mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"strings"
+)
+
+// DiffOptions describe how a file is compared against a table.
+type DiffOptions struct {
+	Table string
+
+	// Key identifies a row in both the file and the table. It must be
+	// unique in each.
+	Key []string
+
+	// Ignore are file columns left out of the comparison, such as ones the
+	// table sets itself.
+	Ignore []string
+
+	// Examples is the number of rows listed of each kind of difference.
+	// Zero means 10.
+	Examples int
+}
+
+// Diff is the outcome of comparing a file against a table.
+type Diff struct {
+	FileRows  int64
+	TableRows int64
+
+	OnlyInFile  int64
+	OnlyInTable int64
+	Differing   int64
+
+	// Columns counts, for each column compared, the rows whose value differs,
+	// in file order.
+	Columns []ColumnDiff
+
+	// Examples of each kind of difference, ordered by key. Values are in
+	// their text form, nil for NULL.
+	FileOnlyKeys  [][]*string
+	TableOnlyKeys [][]*string
+	Differences   []RowDiff
+}
+
+// Matching is the number of rows alike in the file and the table.
+func (d *Diff) Matching() int64 {
+	return d.FileRows - d.OnlyInFile - d.Differing
+}
+
+// Same tells that the file and the table hold the same rows.
+func (d *Diff) Same() bool {
+	return d.OnlyInFile == 0 && d.OnlyInTable == 0 && d.Differing == 0
+}
+
+// ColumnDiff is a column and the number of rows whose value of it differs.
+type ColumnDiff struct {
+	Column string
+	Rows   int64
+}
+
+// RowDiff is a row whose values differ, by its key.
+type RowDiff struct {
+	Key    []*string
+	Values []ValueDiff
+}
+
+// ValueDiff is a value of a column that differs between the file and the
+// table.
+type ValueDiff struct {
+	Column string
+	File   *string
+	Table  *string
+}
+
+// DiffSource compares the records of src against the rows of a table, pairing
+// them by key. The records are copied into a temporary table with the types
+// of the table's columns, so values compare as the table's types do, and the
+// transaction is rolled back, leaving the database as it was. Columns of
+// the table that are not in the file are not compared.
+func DiffSource(ctx context.Context, conn *pgx.Conn, src *Source, opts DiffOptions) (*Diff, error) {
+	if opts.Examples <= 0 {
+		opts.Examples = 10
+	}
+	columns := src.Columns()
+	if err := checkKey(opts.Key, columns); err != nil {
+		return nil, err
+	}
+	var compared []string
+	for _, col := range columns {
+		if !contains(opts.Key, col) && !contains(opts.Ignore, col) {
+			compared = append(compared, col)
+		}
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	d := &Diff{}
+	if d.FileRows, err = stageRecords(ctx, tx, opts.Table, columns, src); err != nil {
+		return nil, err
+	}
+	if err := src.Validate(); err != nil {
+		return nil, err
+	}
+
+	for _, in := range []string{stageTable, opts.Table} {
+		if err := checkUnique(ctx, tx, in, opts.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	counts := make([]interface{}, 0, 4+len(compared))
+	counts = append(counts, &d.TableRows, &d.OnlyInFile, &d.OnlyInTable, &d.Differing)
+	d.Columns = make([]ColumnDiff, len(compared))
+	for i, col := range compared {
+		d.Columns[i].Column = col
+		counts = append(counts, &d.Columns[i].Rows)
+	}
+	if err := tx.QueryRow(ctx, diffCountsSQL(opts.Table, stageTable, opts.Key, compared)).Scan(counts...); err != nil {
+		return nil, err
+	}
+
+	if d.FileOnlyKeys, err = onlyKeys(ctx, tx, onlyInSQL(stageTable, opts.Table, opts.Key, opts.Examples), len(opts.Key)); err != nil {
+		return nil, err
+	}
+	if d.TableOnlyKeys, err = onlyKeys(ctx, tx, onlyInSQL(opts.Table, stageTable, opts.Key, opts.Examples), len(opts.Key)); err != nil {
+		return nil, err
+	}
+	if d.Differences, err = differences(ctx, tx, opts, compared); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func onlyKeys(ctx context.Context, tx pgx.Tx, sql string, n int) ([][]*string, error) {
+	rows, err := tx.Query(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys [][]*string
+	for rows.Next() {
+		key := make([]*string, n)
+		dest := make([]interface{}, n)
+		for i := range key {
+			dest[i] = &key[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func differences(ctx context.Context, tx pgx.Tx, opts DiffOptions, compared []string) ([]RowDiff, error) {
+	rows, err := tx.Query(ctx, differingSQL(opts.Table, stageTable, opts.Key, compared, opts.Examples))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var diffs []RowDiff
+	for rows.Next() {
+		key := make([]*string, len(opts.Key))
+		differs := make([]bool, len(compared))
+		values := make([]ValueDiff, len(compared))
+		dest := make([]interface{}, 0, len(key)+3*len(compared))
+		for i := range key {
+			dest = append(dest, &key[i])
+		}
+		for i := range compared {
+			dest = append(dest, &differs[i], &values[i].File, &values[i].Table)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		row := RowDiff{Key: key}
+		for i, col := range compared {
+			if differs[i] {
+				values[i].Column = col
+				row.Values = append(row.Values, values[i])
+			}
+		}
+		diffs = append(diffs, row)
+	}
+	return diffs, rows.Err()
+}
+
+// textColumns lists columns in their text form, qualified by alias unless it
+// is empty.
+func textColumns(alias string, columns []string) string {
+	list := make([]string, len(columns))
+	for i, col := range columns {
+		list[i] = qualified(alias, col) + "::text"
+	}
+	return strings.Join(list, ", ")
+}
+
+func qualified(alias string, column string) string {
+	q := pgx.Identifier{column}.Sanitize()
+	if alias == "" {
+		return q
+	}
+	return alias + "." + q
+}
+
+// keyMatch pairs the rows of the file, aliased f, with those of the table,
+// aliased t.
+func keyMatch(key []string) string {
+	match := make([]string, len(key))
+	for i, col := range key {
+		match[i] = qualified("f", col) + " = " + qualified("t", col)
+	}
+	return strings.Join(match, " AND ")
+}
+
+// columnDiffers tells that the values of col of a pair of rows differ.
+func columnDiffers(col string) string {
+	return qualified("f", col) + " IS DISTINCT FROM " + qualified("t", col)
+}
+
+// rowDiffers tells that any of the compared columns of a pair of rows
+// differ.
+func rowDiffers(compared []string) string {
+	if len(compared) == 0 {
+		return "false"
+	}
+	conditions := make([]string, len(compared))
+	for i, col := range compared {
+		conditions[i] = columnDiffers(col)
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")"
+}
+
+// diffCountsSQL counts the rows of the table, those only in the file or the
+// table, those differing, and those differing in each compared column. Each
+// side of the full join marks its rows as found, so a missing side shows
+// even for a table or view with NULLs in its key.
+func diffCountsSQL(table string, stage string, key []string, compared []string) string {
+	both := "f.pghurler_found AND t.pghurler_found"
+	counts := []string{
+		"count(*) FILTER (WHERE t.pghurler_found)",
+		"count(*) FILTER (WHERE t.pghurler_found IS NULL)",
+		"count(*) FILTER (WHERE f.pghurler_found IS NULL)",
+		"count(*) FILTER (WHERE " + both + " AND " + rowDiffers(compared) + ")",
+	}
+	for _, col := range compared {
+		counts = append(counts, "count(*) FILTER (WHERE "+both+" AND "+columnDiffers(col)+")")
+	}
+	columns := quoteColumns(append(append([]string(nil), key...), compared...))
+	return "SELECT " + strings.Join(counts, ", ") +
+		" FROM (SELECT " + columns + ", true AS pghurler_found FROM " + quoteTable(stage) + ") f" +
+		" FULL JOIN (SELECT " + columns + ", true AS pghurler_found FROM " + quoteTable(table) + ") t ON " + keyMatch(key)
+}
+
+// onlyInSQL lists the keys of up to limit rows of from that other lacks.
+func onlyInSQL(from string, other string, key []string, limit int) string {
+	match := make([]string, len(key))
+	for i, col := range key {
+		match[i] = qualified("a", col) + " = " + qualified("b", col)
+	}
+	return "SELECT " + textColumns("a", key) + " FROM " + quoteTable(from) + " a WHERE NOT EXISTS (SELECT 1 FROM " +
+		quoteTable(other) + " b WHERE " + strings.Join(match, " AND ") + ") ORDER BY " + qualifiedList("a", key) +
+		fmt.Sprintf(" LIMIT %d", limit)
+}
+
+func qualifiedList(alias string, columns []string) string {
+	list := make([]string, len(columns))
+	for i, col := range columns {
+		list[i] = qualified(alias, col)
+	}
+	return strings.Join(list, ", ")
+}
+
+// differingSQL lists up to limit rows whose values differ: the key, then for
+// each compared column whether it differs and its values in the file and
+// the table.
+func differingSQL(table string, stage string, key []string, compared []string, limit int) string {
+	list := []string{textColumns("f", key)}
+	for _, col := range compared {
+		list = append(list, columnDiffers(col), qualified("f", col)+"::text", qualified("t", col)+"::text")
+	}
+	return "SELECT " + strings.Join(list, ", ") + " FROM " + quoteTable(stage) + " f JOIN " + quoteTable(table) +
+		" t ON " + keyMatch(key) + " WHERE " + rowDiffers(compared) + " ORDER BY " + qualifiedList("f", key) +
+		fmt.Sprintf(" LIMIT %d", limit)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestDiffCountsSQL(t *testing.T) {
+	tests := map[string]struct {
+		key      []string
+		compared []string
+		want     string
+	}{
+		"columns": {
+			[]string{"id"}, []string{"name", "amount"},
+			`SELECT count(*) FILTER (WHERE t.pghurler_found), count(*) FILTER (WHERE t.pghurler_found IS NULL),` +
+				` count(*) FILTER (WHERE f.pghurler_found IS NULL),` +
+				` count(*) FILTER (WHERE f.pghurler_found AND t.pghurler_found AND (f."name" IS DISTINCT FROM t."name" OR f."amount" IS DISTINCT FROM t."amount")),` +
+				` count(*) FILTER (WHERE f.pghurler_found AND t.pghurler_found AND f."name" IS DISTINCT FROM t."name"),` +
+				` count(*) FILTER (WHERE f.pghurler_found AND t.pghurler_found AND f."amount" IS DISTINCT FROM t."amount")` +
+				` FROM (SELECT "id", "name", "amount", true AS pghurler_found FROM "pghurler_stage") f` +
+				` FULL JOIN (SELECT "id", "name", "amount", true AS pghurler_found FROM "events") t ON f."id" = t."id"`,
+		},
+		"key only": {
+			[]string{"region", "id"}, nil,
+			`SELECT count(*) FILTER (WHERE t.pghurler_found), count(*) FILTER (WHERE t.pghurler_found IS NULL),` +
+				` count(*) FILTER (WHERE f.pghurler_found IS NULL),` +
+				` count(*) FILTER (WHERE f.pghurler_found AND t.pghurler_found AND false)` +
+				` FROM (SELECT "region", "id", true AS pghurler_found FROM "pghurler_stage") f` +
+				` FULL JOIN (SELECT "region", "id", true AS pghurler_found FROM "events") t ON f."region" = t."region" AND f."id" = t."id"`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := diffCountsSQL("events", stageTable, tc.key, tc.compared)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("diffCountsSQL() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOnlyInSQL(t *testing.T) {
+	want := `SELECT a."region"::text, a."id"::text FROM "staging"."events" a WHERE NOT EXISTS` +
+		` (SELECT 1 FROM "pghurler_stage" b WHERE a."region" = b."region" AND a."id" = b."id") ORDER BY a."region", a."id" LIMIT 10`
+	got := onlyInSQL("staging.events", stageTable, []string{"region", "id"}, 10)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("onlyInSQL() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDifferingSQL(t *testing.T) {
+	want := `SELECT f."id"::text, f."name" IS DISTINCT FROM t."name", f."name"::text, t."name"::text,` +
+		` f."amount" IS DISTINCT FROM t."amount", f."amount"::text, t."amount"::text` +
+		` FROM "pghurler_stage" f JOIN "events" t ON f."id" = t."id"` +
+		` WHERE (f."name" IS DISTINCT FROM t."name" OR f."amount" IS DISTINCT FROM t."amount") ORDER BY f."id" LIMIT 5`
+	got := differingSQL("events", stageTable, []string{"id"}, []string{"name", "amount"}, 5)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("differingSQL() mismatch (-want +got):\n%s", diff)
+	}
+}
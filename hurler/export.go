@@ -0,0 +1,149 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// ExportOptions describe what is unloaded to a file and how it is written.
+type ExportOptions struct {
+	// Table is unloaded, or Columns of it, unless Query is set instead.
+	Table   string
+	Columns []string
+	Query   string
+
+	// Delimiter separates fields. Zero derives it from the file extension.
+	Delimiter rune
+
+	// Null is written for NULL values. Empty strings are then quoted to
+	// tell them apart.
+	Null string
+
+	// NoHeader leaves out the header row of column names.
+	NoHeader bool
+}
+
+// ExportResult tells what an export wrote.
+type ExportResult struct {
+	Path string
+	Rows int64
+}
+
+// Export unloads a table or query into the delimited file at path with COPY
+// TO STDOUT, in CSV format so values with delimiters, quotes or newlines
+// read back as they were. A path ending in .gz is compressed. The file is
+// written under a temporary name and renamed once complete, so a failed
+// export leaves nothing behind. The session is made read only.
+func Export(ctx context.Context, conn *pgx.Conn, path string, opts ExportOptions) (*ExportResult, error) {
+	sql, err := copyToSQL(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Exec(ctx, "SET default_transaction_read_only = on"); err != nil {
+		return nil, err
+	}
+
+	f, err := createTemp(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name()) // gone once renamed
+	defer f.Close()
+
+	var out io.Writer = f
+	var zw *gzip.Writer
+	if strings.HasSuffix(path, ".gz") {
+		zw = gzip.NewWriter(f)
+		out = zw
+	}
+
+	tag, err := conn.PgConn().CopyTo(ctx, out, sql)
+	if err != nil {
+		return nil, err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return nil, err
+	}
+	return &ExportResult{Path: path, Rows: tag.RowsAffected()}, nil
+}
+
+// createTemp creates a new file in dir with a hidden name made from base.
+// Unlike ioutil.TempFile, whose files are private, it asks for mode 0644
+// and lets the umask narrow it, as for any other file written.
+func createTemp(dir string, base string) (*os.File, error) {
+	for try := 0; ; try++ {
+		var b [8]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return nil, err
+		}
+		name := filepath.Join(dir, "."+base+"."+hex.EncodeToString(b[:]))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) && try < 100 {
+			continue
+		}
+		return f, err
+	}
+}
+
+// CheckExport reports whether opts are sound for exporting to path, so a
+// mistake shows before connecting.
+func CheckExport(path string, opts ExportOptions) error {
+	_, err := copyToSQL(path, opts)
+	return err
+}
+
+// copyToSQL builds the COPY TO STDOUT statement writing the file at path.
+func copyToSQL(path string, opts ExportOptions) (string, error) {
+	delimiter := opts.Delimiter
+	if delimiter == 0 {
+		d, err := DeriveDelimiter(path)
+		if err != nil {
+			return "", err
+		}
+		delimiter = d
+	}
+	if delimiter >= utf8.RuneSelf {
+		return "", fmt.Errorf("delimiter '%c' is not a single byte character", delimiter)
+	}
+
+	var source string
+	switch {
+	case opts.Query != "" && opts.Table != "":
+		return "", errors.New("export a table or a query, not both")
+	case opts.Query != "" && len(opts.Columns) > 0:
+		return "", errors.New("columns are chosen by the query")
+	case opts.Query != "":
+		source = parenthesize(opts.Query)
+	case opts.Table != "" && len(opts.Columns) > 0:
+		source = "(SELECT " + quoteColumns(opts.Columns) + " FROM " + quoteTable(opts.Table) + ")"
+	case opts.Table != "":
+		source = "(SELECT * FROM " + quoteTable(opts.Table) + ")"
+	default:
+		return "", errors.New("nothing to export: no table or query")
+	}
+
+	return fmt.Sprintf("COPY %s TO STDOUT WITH (FORMAT csv, DELIMITER %s, NULL %s, HEADER %t)",
+		source, quoteLiteral(string(delimiter)), quoteLiteral(opts.Null), !opts.NoHeader), nil
+}
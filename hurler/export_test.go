@@ -0,0 +1,50 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestCopyToSQL(t *testing.T) {
+	tests := map[string]struct {
+		path string
+		opts ExportOptions
+		want string
+		err  string
+	}{
+		"table": {"out.csv", ExportOptions{Table: "staging.events"},
+			`COPY (SELECT * FROM "staging"."events") TO STDOUT WITH (FORMAT csv, DELIMITER ',', NULL '', HEADER true)`, ""},
+		"columns": {"out.tsv.gz", ExportOptions{Table: "events", Columns: []string{"id", "Name"}, Null: `\N`},
+			"COPY (SELECT \"id\", \"Name\" FROM \"events\") TO STDOUT WITH (FORMAT csv, DELIMITER '\t', NULL '\\N', HEADER true)", ""},
+		"query": {"out.txt", ExportOptions{Query: "select 'it''s';\n", Delimiter: '|', NoHeader: true},
+			"COPY (select 'it''s'\n) TO STDOUT WITH (FORMAT csv, DELIMITER '|', NULL '', HEADER false)", ""},
+
+		"both":           {"out.csv", ExportOptions{Table: "events", Query: "select 1"}, "", "export a table or a query, not both"},
+		"query columns":  {"out.csv", ExportOptions{Query: "select 1", Columns: []string{"id"}}, "", "columns are chosen by the query"},
+		"nothing":        {"out.csv", ExportOptions{}, "", "nothing to export: no table or query"},
+		"wide delimiter": {"out.txt", ExportOptions{Table: "events", Delimiter: '¦'}, "", "delimiter '¦' is not a single byte character"},
+		"unknown ext":    {"out.foo", ExportOptions{Table: "events"}, "", "could not derive delimiter from '.foo' extension"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := copyToSQL(tc.path, tc.opts)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Errorf("copyToSQL() error = %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("copyToSQL() failed: %s", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("copyToSQL() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -2,16 +2,19 @@
 Copyright © 2019 Jason Walker <ragin.jason@me.com>
 This file is part of pghurler.
 */
-package main
+package hurler
 
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
 )
 
-func deriveDelimiter(path string) (rune, error) {
+// DeriveDelimiter picks the field delimiter of a file from its extension,
+// looking past a .gz suffix.
+func DeriveDelimiter(path string) (rune, error) {
 	var delimiter rune
-	ext := filepath.Ext(path)
+	ext := filepath.Ext(strings.TrimSuffix(path, ".gz"))
 	switch ext {
 	case ".csv":
 		delimiter = ','
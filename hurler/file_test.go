@@ -1,4 +1,4 @@
-package main
+package hurler
 
 /*
 import (
@@ -36,14 +36,14 @@ func TestDeriveDelimiter(t *testing.T) {
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
-			d, err := deriveDelimiter(tc.filepath)
+			d, err := DeriveDelimiter(tc.filepath)
 
 			if diff := cmp.Diff(tc.want, d); diff != "" {
-				t.Errorf("deriveDelimiter(%q) mismatch (-want +got):\n%s", tc.filepath, diff)
+				t.Errorf("DeriveDelimiter(%q) mismatch (-want +got):\n%s", tc.filepath, diff)
 			}
 
 			if diff := cmp.Diff(tc.err, err, equateErrorMessage); diff != "" {
-				t.Errorf("Error mismatch for deriveDelimiter(%q) (-want +got):\n%s", tc.filepath, diff)
+				t.Errorf("Error mismatch for DeriveDelimiter(%q) (-want +got):\n%s", tc.filepath, diff)
 			}
 		})
 	}
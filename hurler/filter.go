@@ -0,0 +1,511 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Filter decides which records are loaded. It is built from rules written in
+// a small SQL-like expression language evaluated against Record.Values:
+//
+//	region IN ('EU', 'US') AND NOT (amount < 0 OR id ~ '^TEST')
+//	"Record Type" != 'TRL'
+//	closed_on IS NULL
+//
+// Comparisons (=, !=, <>, <, <=, >, >=) are numeric when both sides are
+// numbers and textual otherwise; ~ and !~ match a regular expression. A
+// comparison involving a NULL value is false, as in SQL.
+type Filter struct {
+	rules []*filterRule
+}
+
+type filterRule struct {
+	text    string
+	skip    bool // a matching record is skipped, rather than kept
+	expr    filterExpr
+	columns []string // the columns expr refers to
+	skipped uint64
+}
+
+// FilterStat reports how many records a filter rule skipped.
+type FilterStat struct {
+	Rule    string
+	Skipped uint64
+}
+
+// NewFilter builds a filter that drops records matching any skip expression
+// and keeps only records matching every where expression. Skip rules are
+// checked first, so records such as trailers are counted against them.
+func NewFilter(where []string, skip []string) (*Filter, error) {
+	f := &Filter{}
+	for _, text := range skip {
+		if err := f.add(text, true); err != nil {
+			return nil, err
+		}
+	}
+	for _, text := range where {
+		if err := f.add(text, false); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (f *Filter) add(text string, skip bool) error {
+	expr, columns, err := parseFilter(text)
+	if err != nil {
+		return fmt.Errorf("filter %q: %s", text, err)
+	}
+	f.rules = append(f.rules, &filterRule{text: text, skip: skip, expr: expr, columns: columns})
+	return nil
+}
+
+// CheckColumns fails if a rule refers to a column not among columns, which
+// it would compare as missing from every record.
+func (f *Filter) CheckColumns(columns []string) error {
+	for _, rule := range f.rules {
+		for _, col := range rule.columns {
+			if !contains(columns, col) {
+				return fmt.Errorf("filter %q: unknown column '%s'", rule.text, col)
+			}
+		}
+	}
+	return nil
+}
+
+// Keep reports whether rec passes the filter. A skipped record is counted
+// against the first rule that rejected it. Keep may be called concurrently.
+func (f *Filter) Keep(rec *Record) bool {
+	for _, rule := range f.rules {
+		if rule.expr.eval(rec) == rule.skip {
+			atomic.AddUint64(&rule.skipped, 1)
+			return false
+		}
+	}
+	return true
+}
+
+// Stats returns the number of records each rule skipped, in the order the
+// rules are checked. Rules are labelled with a "skip " or "where " prefix.
+func (f *Filter) Stats() []FilterStat {
+	var stats []FilterStat
+	for _, rule := range f.rules {
+		prefix := "where "
+		if rule.skip {
+			prefix = "skip "
+		}
+		stats = append(stats, FilterStat{Rule: prefix + rule.text, Skipped: atomic.LoadUint64(&rule.skipped)})
+	}
+	return stats
+}
+
+// FilteringReader passes on the records read from a RecordReader that a
+// Filter keeps.
+type FilteringReader struct {
+	source RecordReader
+	filter *Filter
+}
+
+func NewFilteringReader(source RecordReader, filter *Filter) *FilteringReader {
+	return &FilteringReader{source: source, filter: filter}
+}
+
+func (r *FilteringReader) Read() (*Record, error) {
+	for {
+		rec, err := r.source.Read()
+		if err != nil {
+			return nil, err
+		}
+		if r.filter.Keep(rec) {
+			return rec, nil
+		}
+	}
+}
+
+// filterExpr is a node of a parsed filter expression.
+type filterExpr interface {
+	eval(rec *Record) bool
+}
+
+type andExpr struct{ left, right filterExpr }
+type orExpr struct{ left, right filterExpr }
+type notExpr struct{ expr filterExpr }
+
+type compareExpr struct {
+	column string
+	op     string
+	value  string
+}
+
+type matchExpr struct {
+	column string
+	re     *regexp.Regexp
+	negate bool
+}
+
+type inExpr struct {
+	column string
+	values []string
+	negate bool
+}
+
+type isNullExpr struct {
+	column string
+	negate bool
+}
+
+func (e *andExpr) eval(rec *Record) bool { return e.left.eval(rec) && e.right.eval(rec) }
+func (e *orExpr) eval(rec *Record) bool  { return e.left.eval(rec) || e.right.eval(rec) }
+func (e *notExpr) eval(rec *Record) bool { return !e.expr.eval(rec) }
+
+func (e *isNullExpr) eval(rec *Record) bool {
+	return rec.IsNull(e.column) != e.negate
+}
+
+func (e *matchExpr) eval(rec *Record) bool {
+	if rec.IsNull(e.column) {
+		return false
+	}
+	return e.re.MatchString(rec.Values[e.column]) != e.negate
+}
+
+func (e *inExpr) eval(rec *Record) bool {
+	if rec.IsNull(e.column) {
+		return false
+	}
+	for _, v := range e.values {
+		if compareValues(rec.Values[e.column], v) == 0 {
+			return !e.negate
+		}
+	}
+	return e.negate
+}
+
+func (e *compareExpr) eval(rec *Record) bool {
+	if rec.IsNull(e.column) {
+		return false
+	}
+	c := compareValues(rec.Values[e.column], e.value)
+	switch e.op {
+	case "=":
+		return c == 0
+	case "!=", "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// compareValues compares a and b as numbers if both are numeric, and as
+// strings otherwise.
+func compareValues(a string, b string) int {
+	x, errA := strconv.ParseFloat(strings.TrimSpace(a), 64)
+	y, errB := strconv.ParseFloat(strings.TrimSpace(b), 64)
+	if errA == nil && errB == nil {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
+
+// Filter expression tokens.
+const (
+	tokEOF = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind   int
+	text   string
+	pos    int
+	quoted bool // a "quoted" identifier, which is never a keyword
+}
+
+func tokenizeFilter(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case unicode.IsSpace(c):
+			i += size
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "(", i, false})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")", i, false})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokComma, ",", i, false})
+			i++
+		case c == '\'' || c == '"':
+			kind := tokString
+			if c == '"' {
+				kind = tokIdent
+			}
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(s); j++ {
+				if s[j] == byte(c) {
+					if j+1 < len(s) && s[j+1] == byte(c) {
+						b.WriteByte(byte(c))
+						j++
+						continue
+					}
+					break
+				}
+				b.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated %c at position %d", c, i+1)
+			}
+			tokens = append(tokens, token{kind, b.String(), i, true})
+			i = j + 1
+		case strings.ContainsRune("=!<>~", c):
+			j := i + 1
+			for j < len(s) && strings.ContainsRune("=<>~", rune(s[j])) {
+				j++
+			}
+			op := s[i:j]
+			switch op {
+			case "=", "!=", "<>", "<", "<=", ">", ">=", "~", "!~":
+			default:
+				return nil, fmt.Errorf("unknown operator '%s' at position %d", op, i+1)
+			}
+			tokens = append(tokens, token{tokOp, op, i, false})
+			i = j
+		case c == '-' || c == '.' || unicode.IsDigit(c):
+			j := scanWhile(s, i+size, func(r rune) bool { return r == '.' || unicode.IsDigit(r) })
+			tokens = append(tokens, token{tokNumber, s[i:j], i, false})
+			i = j
+		case c == '_' || unicode.IsLetter(c):
+			j := scanWhile(s, i+size, func(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) })
+			tokens = append(tokens, token{tokIdent, s[i:j], i, false})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected '%c' at position %d", c, i+1)
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(s), false}), nil
+}
+
+// scanWhile returns the position of the first character of s from i on that
+// does not satisfy ok.
+func scanWhile(s string, i int, ok func(rune) bool) int {
+	for i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if !ok(r) {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+type filterParser struct {
+	tokens  []token
+	pos     int
+	columns []string // the columns named so far
+}
+
+// parseFilter parses a filter expression, returning the columns it names
+// too.
+func parseFilter(s string) (filterExpr, []string, error) {
+	tokens, err := tokenizeFilter(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, nil, fmt.Errorf("unexpected '%s' at position %d", t.text, t.pos+1)
+	}
+	return expr, p.columns, nil
+}
+
+func (p *filterParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword reports whether the next token is the bare word kw, consuming it
+// if so.
+func (p *filterParser) keyword(kw string) bool {
+	t := p.peek()
+	if t.kind == tokIdent && !t.quoted && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (filterExpr, error) {
+	if p.keyword("NOT") {
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{expr}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (filterExpr, error) {
+	if p.peek().kind == tokLParen {
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokRParen {
+			return nil, fmt.Errorf("expected ')' at position %d", t.pos+1)
+		}
+		return expr, nil
+	}
+
+	t := p.next()
+	if t.kind != tokIdent {
+		return nil, fmt.Errorf("expected column name at position %d", t.pos+1)
+	}
+	column := t.text
+	if !contains(p.columns, column) {
+		p.columns = append(p.columns, column)
+	}
+
+	switch {
+	case p.keyword("IS"):
+		negate := p.keyword("NOT")
+		if !p.keyword("NULL") {
+			return nil, fmt.Errorf("expected NULL at position %d", p.peek().pos+1)
+		}
+		return &isNullExpr{column, negate}, nil
+	case p.keyword("NOT"):
+		if !p.keyword("IN") {
+			return nil, fmt.Errorf("expected IN at position %d", p.peek().pos+1)
+		}
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return &inExpr{column, values, true}, nil
+	case p.keyword("IN"):
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return &inExpr{column, values, false}, nil
+	}
+
+	op := p.next()
+	if op.kind != tokOp {
+		return nil, fmt.Errorf("expected operator at position %d", op.pos+1)
+	}
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	if op.text == "~" || op.text == "!~" {
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, err
+		}
+		return &matchExpr{column, re, op.text == "!~"}, nil
+	}
+	return &compareExpr{column, op.text, value}, nil
+}
+
+func (p *filterParser) parseValue() (string, error) {
+	t := p.next()
+	if t.kind != tokString && t.kind != tokNumber {
+		return "", fmt.Errorf("expected value at position %d", t.pos+1)
+	}
+	return t.text, nil
+}
+
+func (p *filterParser) parseList() ([]string, error) {
+	if t := p.next(); t.kind != tokLParen {
+		return nil, fmt.Errorf("expected '(' at position %d", t.pos+1)
+	}
+	var values []string
+	for {
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		t := p.next()
+		if t.kind == tokRParen {
+			return values, nil
+		}
+		if t.kind != tokComma {
+			return nil, fmt.Errorf("expected ',' or ')' at position %d", t.pos+1)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"strings"
+	"testing"
+)
+
+func TestFilterKeep(t *testing.T) {
+
+	rec := &Record{
+		Values: map[string]string{"id": "TEST-7", "region": "EU", "amount": "10.5", "Record Type": "DTL", "closed_on": "", "région": "Île-de-France"},
+		Nulls:  map[string]bool{"closed_on": true},
+	}
+
+	tests := map[string]struct {
+		expr string
+		want bool
+	}{
+		"equal string":         {"region = 'EU'", true},
+		"not equal":            {"region <> 'EU'", false},
+		"numeric less":         {"amount < 9", false},
+		"numeric not textual":  {"amount > 9", true},
+		"numeric equal":        {"amount = 10.50", true},
+		"regex":                {"id ~ '^TEST'", true},
+		"negated regex":        {"id !~ '^TEST'", false},
+		"in list":              {"region IN ('US', 'EU')", true},
+		"not in list":          {"region not in ('US', 'EU')", false},
+		"quoted column":        {`"Record Type" != 'TRL'`, true},
+		"is null":              {"closed_on IS NULL", true},
+		"is not null":          {"closed_on IS NOT NULL", false},
+		"null compare":         {"closed_on = ''", false},
+		"null not equal":       {"closed_on != 'x'", false},
+		"and":                  {"region = 'EU' AND amount > 100", false},
+		"or":                   {"region = 'US' OR amount > 10", true},
+		"not":                  {"NOT region = 'US'", true},
+		"precedence":           {"region = 'US' AND amount > 100 OR id ~ 'TEST'", true},
+		"parens":               {"region = 'US' AND (amount > 100 OR id ~ 'TEST')", false},
+		"escaped quote":        {"id != 'O''Brien'", true},
+		"keyword as a column":  {`"in" IS NULL`, false},
+		"missing column empty": {"missing = ''", true},
+		"non-ASCII column":     {"région ~ '^Île'", true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			f, err := NewFilter([]string{tc.expr}, nil)
+			if err != nil {
+				t.Fatalf("NewFilter(%q) failed: %s", tc.expr, err)
+			}
+
+			if diff := cmp.Diff(tc.want, f.Keep(rec)); diff != "" {
+				t.Errorf("Keep() for %q mismatch (-want +got):\n%s", tc.expr, diff)
+			}
+		})
+	}
+}
+
+func TestNewFilterErrors(t *testing.T) {
+
+	tests := map[string]struct {
+		expr string
+		err  error
+	}{
+		"empty":            {"", errors.New(`filter "": expected column name at position 1`)},
+		"no value":         {"a =", errors.New(`filter "a =": expected value at position 4`)},
+		"bad operator":     {"a => 1", errors.New(`filter "a => 1": unknown operator '=>' at position 3`)},
+		"unterminated":     {"a = 'x", errors.New(`filter "a = 'x": unterminated ' at position 5`)},
+		"unclosed paren":   {"(a = 1", errors.New(`filter "(a = 1": expected ')' at position 7`)},
+		"trailing garbage": {"a = 1 b", errors.New(`filter "a = 1 b": unexpected 'b' at position 7`)},
+		"non-ASCII symbol": {"a ≠ 1", errors.New(`filter "a ≠ 1": unexpected '≠' at position 3`)},
+		"bad regex":        {"a ~ '('", errors.New("filter \"a ~ '('\": error parsing regexp: missing closing ): `(`")},
+		"is without null":  {"a IS 1", errors.New(`filter "a IS 1": expected NULL at position 6`)},
+		"bad list":         {"a IN ('x' 'y')", errors.New(`filter "a IN ('x' 'y')": expected ',' or ')' at position 11`)},
+	}
+
+	equateErrorMessage := cmp.Comparer(func(x, y error) bool {
+		if x == nil || y == nil {
+			return x == nil && y == nil
+		}
+		return x.Error() == y.Error()
+	})
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewFilter([]string{tc.expr}, nil)
+
+			if diff := cmp.Diff(tc.err, err, equateErrorMessage); diff != "" {
+				t.Errorf("Error mismatch for NewFilter(%q) (-want +got):\n%s", tc.expr, diff)
+			}
+		})
+	}
+}
+
+func TestFilteringReaderStats(t *testing.T) {
+	input := "type,region,amount\nHDR,,\nDTL,EU,1\nDTL,US,2\nDTL,APAC,3\nDTL,EU,-4\nTRL,,4"
+
+	r, err := NewDelimitedReader(strings.NewReader(input), ',')
+	if err != nil {
+		t.Fatalf("NewDelimitedReader() failed: %s", err)
+	}
+
+	f, err := NewFilter([]string{"region IN ('EU', 'US')"}, []string{"type IN ('HDR', 'TRL')", "amount < 0"})
+	if err != nil {
+		t.Fatalf("NewFilter() failed: %s", err)
+	}
+
+	fr := NewFilteringReader(r, f)
+	var got []uint64
+	for {
+		rec, err := fr.Read()
+		if err != nil {
+			break
+		}
+		got = append(got, rec.LineNumber)
+	}
+
+	if diff := cmp.Diff([]uint64{3, 4}, got); diff != "" {
+		t.Errorf("FilteringReader lines mismatch (-want +got):\n%s", diff)
+	}
+
+	wantStats := []FilterStat{
+		{Rule: "skip type IN ('HDR', 'TRL')", Skipped: 2},
+		{Rule: "skip amount < 0", Skipped: 1},
+		{Rule: "where region IN ('EU', 'US')", Skipped: 1},
+	}
+	if diff := cmp.Diff(wantStats, f.Stats()); diff != "" {
+		t.Errorf("Stats() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFilterCheckColumns(t *testing.T) {
+	f, err := NewFilter([]string{"region = 'EU' AND NOT (amount < 0 OR \"Record Type\" IS NULL)"}, []string{"regoin = 'EU'"})
+	if err != nil {
+		t.Fatalf("NewFilter() failed: %s", err)
+	}
+
+	if err := f.CheckColumns([]string{"region", "regoin", "amount", "Record Type"}); err != nil {
+		t.Errorf("CheckColumns() failed: %s", err)
+	}
+	want := `filter "regoin = 'EU'": unknown column 'regoin'`
+	if err := f.CheckColumns([]string{"region", "amount", "Record Type"}); err == nil || err.Error() != want {
+		t.Errorf("CheckColumns() error = %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Preview is the head of a file as a load would read it.
+type Preview struct {
+	Columns []string
+	Records []*Record
+
+	// Problems are the records among them that failed to parse or convert.
+	Problems []Problem
+}
+
+// Head reads the first n records of src, going on past records that fail
+// to parse or convert, which are reported as problems and not counted.
+func Head(src *Source, n int) (*Preview, error) {
+	p := &Preview{Columns: src.Columns()}
+	for len(p.Records) < n {
+		rec, err := src.Read()
+		if err == io.EOF {
+			break
+		}
+		if problem, ok := recordProblem(err); ok {
+			p.Problems = append(p.Problems, problem)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.Records = append(p.Records, rec)
+	}
+	return p, nil
+}
+
+// Markers that make values that look alike on a terminal tell apart.
+const (
+	NullMarker    = "∅"
+	SpaceMarker   = "·"
+	TabMarker     = "→"
+	NewlineMarker = "↵"
+	ReturnMarker  = "␍"
+)
+
+// Visible makes the whitespace of value visible: leading and trailing
+// spaces become SpaceMarker, tabs, newlines and carriage returns their
+// markers, and other control and space characters are escaped. So that
+// neither markers nor escapes are mistaken for the value's own text, the
+// marker characters are escaped too, and backslashes doubled.
+func Visible(value string) string {
+	trimmed := strings.TrimRight(strings.TrimLeft(value, " "), " ")
+	if trimmed == "" {
+		return strings.Repeat(SpaceMarker, len(value))
+	}
+	leading := strings.Index(value, trimmed)
+	trailing := len(value) - leading - len(trimmed)
+
+	var b strings.Builder
+	b.WriteString(strings.Repeat(SpaceMarker, leading))
+	for _, r := range trimmed {
+		switch {
+		case r == '\t':
+			b.WriteString(TabMarker)
+		case r == '\n':
+			b.WriteString(NewlineMarker)
+		case r == '\r':
+			b.WriteString(ReturnMarker)
+		case r == ' ':
+			b.WriteRune(r)
+		case r == '\\':
+			b.WriteString(`\\`)
+		case unicode.IsControl(r) || unicode.IsSpace(r) || isMarker(r):
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteString(strings.Repeat(SpaceMarker, trailing))
+	return b.String()
+}
+
+// isMarker reports whether r is the character of one of the markers.
+func isMarker(r rune) bool {
+	for _, m := range []string{NullMarker, SpaceMarker, TabMarker, NewlineMarker, ReturnMarker} {
+		if string(r) == m {
+			return true
+		}
+	}
+	return false
+}
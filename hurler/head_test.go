@@ -0,0 +1,77 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestHead(t *testing.T) {
+	f, err := ioutil.TempFile("", "*.tsv")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err)
+	}
+	defer os.Remove(f.Name()) // clean up
+	f.WriteString("id\tname\n" +
+		"1\t\\N\n" +
+		"2\t\"a\"b\n" +
+		"3\t\"two\nlines\"\n" +
+		"4\tlast\n")
+	f.Close()
+
+	src, err := OpenSource(f.Name(), SourceOptions{Nulls: &NullRules{Markers: []string{`\N`}}})
+	if err != nil {
+		t.Fatalf("OpenSource() failed: %s", err)
+	}
+	defer src.Close()
+
+	got, err := Head(src, 2)
+	if err != nil {
+		t.Fatalf("Head() failed: %s", err)
+	}
+	if diff := cmp.Diff([]string{"id", "name"}, got.Columns); diff != "" {
+		t.Errorf("Head() columns mismatch (-want +got):\n%s", diff)
+	}
+	if len(got.Records) != 2 {
+		t.Fatalf("Head() read %d records, want 2", len(got.Records))
+	}
+	if !got.Records[0].IsNull("name") {
+		t.Errorf("Head() record 1 name is not NULL")
+	}
+	if v := got.Records[1].Values["name"]; v != "two\nlines" {
+		t.Errorf("Head() record 2 name = %q, want %q", v, "two\nlines")
+	}
+	if len(got.Problems) != 1 || got.Problems[0].Line != 3 {
+		t.Errorf("Head() problems = %v, want one on line 3", got.Problems)
+	}
+}
+
+func TestVisible(t *testing.T) {
+	var tests = []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"plain text", "plain text"},
+		{"  padded ", "··padded·"},
+		{"   ", "···"},
+		{"a\tb", "a→b"},
+		{"two\r\nlines", "two␍↵lines"},
+		{"nb\u00a0sp", `nb\u00a0sp`},
+		{"bell\a", `bell\u0007`},
+		{"∅", `\u2205`},
+		{"a→b", `a\u2192b`},
+		{`C:\u0041`, `C:\\u0041`},
+	}
+
+	for _, tt := range tests {
+		if got := Visible(tt.value); got != tt.want {
+			t.Errorf("Visible(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestHyperLogLog(t *testing.T) {
+
+	for _, n := range []int{0, 10, 1000, 100000, 1000000} {
+		var h hyperLogLog
+		for i := 0; i < n; i++ {
+			v := "value-" + strconv.Itoa(i)
+			h.add(v)
+			h.add(v) // repeats do not count
+		}
+
+		got := h.estimate()
+		if diff := math.Abs(float64(got) - float64(n)); diff > 0.03*float64(n)+1 {
+			t.Errorf("estimate() of %d distinct values = %d, off by more than 3%%", n, got)
+		}
+	}
+}
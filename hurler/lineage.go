@@ -0,0 +1,70 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Lineage columns, which trace a loaded row back to its place in the file.
+const (
+	LineageSourceFile   = "_source_file"   // path of the file
+	LineageSourceLine   = "_source_line"   // line the record starts on
+	LineageRecordNumber = "_record_number" // position among the file's records
+	LineageLoadID       = "_load_id"       // the load's id in pghurler_loads
+	LineageLoadedAt     = "_loaded_at"     // time the load started
+)
+
+// LineageColumns lists every lineage column.
+var LineageColumns = []string{LineageSourceFile, LineageSourceLine, LineageRecordNumber, LineageLoadID, LineageLoadedAt}
+
+// Lineage is where the rows of a load come from.
+type Lineage struct {
+	SourceFile string
+	LoadID     int64
+	LoadedAt   time.Time
+}
+
+// LineageReader sets the lineage columns of each record.
+type LineageReader struct {
+	source  RecordReader
+	columns []string
+	lineage Lineage
+}
+
+// NewLineageReader returns a LineageReader setting columns, which must be
+// lineage columns, on the records of source.
+func NewLineageReader(source RecordReader, columns []string, lineage Lineage) (*LineageReader, error) {
+	for _, col := range columns {
+		if !contains(LineageColumns, col) {
+			return nil, fmt.Errorf("unknown lineage column '%s'", col)
+		}
+	}
+	return &LineageReader{source: source, columns: columns, lineage: lineage}, nil
+}
+
+func (r *LineageReader) Read() (*Record, error) {
+	rec, err := r.source.Read()
+	if err != nil {
+		return nil, err
+	}
+	for _, col := range r.columns {
+		switch col {
+		case LineageSourceFile:
+			rec.Set(col, r.lineage.SourceFile)
+		case LineageSourceLine:
+			rec.Set(col, strconv.FormatUint(rec.LineNumber, 10))
+		case LineageRecordNumber:
+			rec.Set(col, strconv.FormatUint(rec.RecordNumber, 10))
+		case LineageLoadID:
+			rec.Set(col, strconv.FormatInt(r.lineage.LoadID, 10))
+		case LineageLoadedAt:
+			rec.Set(col, r.lineage.LoadedAt.Format("2006-01-02 15:04:05.999999-07:00"))
+		}
+	}
+	return rec, nil
+}
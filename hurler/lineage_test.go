@@ -0,0 +1,55 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLineageReader(t *testing.T) {
+	in := "id,name\n1,ada\n\n2,\"grace\nhopper\"\n3,alan\n"
+	reader, err := NewDelimitedReader(strings.NewReader(in), ',')
+	if err != nil {
+		t.Fatalf("NewDelimitedReader() failed: %s", err)
+	}
+
+	lineage := Lineage{SourceFile: "/data/people.csv", LoadID: 42, LoadedAt: time.Date(2019, 6, 1, 8, 30, 0, 0, time.UTC)}
+	r, err := NewLineageReader(reader, LineageColumns, lineage)
+	if err != nil {
+		t.Fatalf("NewLineageReader() failed: %s", err)
+	}
+
+	var got []map[string]string
+	for {
+		rec, err := r.Read()
+		if err != nil {
+			break
+		}
+		got = append(got, rec.Values)
+	}
+
+	row := func(id, name, line, number string) map[string]string {
+		return map[string]string{
+			"id": id, "name": name,
+			"_source_file": "/data/people.csv", "_source_line": line, "_record_number": number,
+			"_load_id": "42", "_loaded_at": "2019-06-01 08:30:00+00:00",
+		}
+	}
+	want := []map[string]string{
+		row("1", "ada", "2", "1"),
+		row("2", "grace\nhopper", "4", "2"),
+		row("3", "alan", "6", "3"),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("LineageReader records mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := NewLineageReader(reader, []string{"_batch"}, lineage); err == nil {
+		t.Errorf("NewLineageReader() of an unknown column succeeded")
+	}
+}
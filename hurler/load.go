@@ -0,0 +1,431 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"io"
+	"strings"
+)
+
+// Load modes.
+const (
+	ModeAppend  = "append"  // COPY records straight into the table
+	ModeUpsert  = "upsert"  // insert new keys and update existing ones
+	ModeReplace = "replace" // replace the table's content atomically
+	ModeSCD2    = "scd2"    // keep every version of each row
+)
+
+// LoadOptions describe where and how a Source is loaded.
+type LoadOptions struct {
+	// Table is the target table, optionally qualified by its schema.
+	Table string
+
+	// Mode is one of the load modes. Empty means ModeAppend.
+	Mode string
+
+	// Key names the columns of the conflict target for ModeUpsert, or the
+	// business key of ModeSCD2.
+	Key []string
+
+	// OnlyChanged makes ModeUpsert leave rows alone whose non-key values
+	// are unchanged.
+	OnlyChanged bool
+
+	// Replace is the strategy of ModeReplace. Empty means ReplaceSwap.
+	Replace string
+
+	// Effective names the file column holding the date new ModeSCD2
+	// versions are valid from. It is not loaded itself. Empty means the
+	// load time.
+	Effective string
+
+	// Deletes makes ModeUpsert treat the file as a full snapshot and
+	// delete target rows whose key is absent from it, either DeleteSoft
+	// or DeleteHard. Empty means rows are never deleted.
+	Deletes string
+
+	// DeletedAt is the column DeleteSoft sets to the load time. It
+	// defaults to deleted_at.
+	DeletedAt string
+
+	// MaxDeletePercent is the largest share of the table's rows that may
+	// be deleted before the load is refused as a likely truncated file.
+	MaxDeletePercent float64
+
+	// Lineage names the lineage columns to add to every loaded row.
+	Lineage []string
+
+	// Workers is the number of connections ModeAppend copies the file
+	// over, each loading a part of it. Zero or one loads it sequentially.
+	Workers int
+
+	// CreatePartitions creates the partitions of a partitioned target
+	// that the records' key values need and it lacks. Range partitions
+	// of date and timestamp keys span PartitionInterval; list partitions
+	// take a single value.
+	CreatePartitions bool
+
+	// PartitionInterval is PartitionDay, PartitionMonth or PartitionYear.
+	// Empty means PartitionMonth.
+	PartitionInterval string
+
+	// LeafCopy copies records straight into the leaf partitions of a
+	// partitioned target rather than through the parent.
+	LeafCopy bool
+
+	// Force loads a file even if the manifest shows the same content was
+	// already loaded into the table.
+	Force bool
+
+	// CommitEvery makes a sequential ModeAppend commit the records in
+	// transactions of this many, so that a retried load resumes after the
+	// last record committed. The file is then no longer loaded all or
+	// nothing: its control totals are only checked with the last
+	// transaction. Zero loads it in a single transaction.
+	CommitEvery int
+}
+
+// Delete strategies for rows absent from a snapshot.
+const (
+	DeleteSoft = "soft" // set the DeletedAt column
+	DeleteHard = "hard" // delete the row
+)
+
+func (opts LoadOptions) deletedAt() string {
+	if opts.DeletedAt == "" {
+		return "deleted_at"
+	}
+	return opts.DeletedAt
+}
+
+// LoadResult summarizes a load.
+type LoadResult struct {
+	LoadID   int64 // the load's row in pghurler_loads
+	Rows     int64 // records read from the source
+	Inserted int64
+	Updated  int64
+	Deleted  int64
+	Skipped  []FilterStat
+}
+
+// Load copies every record of src into the target table in one transaction.
+// The source's control totals are checked before committing, so a file that
+// fails them leaves the table untouched. Each load, successful or not, is
+// recorded in the pghurler_loads table.
+//
+// Loads split by opts.CommitEvery or opts.Workers are the exception: their
+// parts commit in transactions of their own, not atomically with each other
+// or with the manifest, so a failed load may leave some parts loaded. The
+// manifest records which, so that another attempt at the load goes on from
+// them, and a later load of the same file is refused unless opts.Force.
+func Load(ctx context.Context, conn *pgx.Conn, src *Source, opts LoadOptions) (*LoadResult, error) {
+	res, _, err := loadAttempt(ctx, conn, src, opts, nil)
+	return res, err
+}
+
+// loadAttempt is Load, going on with the load recorded by entry if it is
+// not nil. It returns the load's manifest entry, once there is one, so
+// that a later attempt can go on with it in turn.
+func loadAttempt(ctx context.Context, conn *pgx.Conn, src *Source, opts LoadOptions, entry *manifestEntry) (*LoadResult, *manifestEntry, error) {
+	load, err := loadFor(src, opts)
+	if err != nil {
+		return nil, entry, err
+	}
+
+	if entry == nil {
+		if entry, err = startManifest(ctx, conn, src.Path, opts); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		done, err := entry.resume(ctx, conn)
+		if err != nil {
+			return nil, entry, err
+		}
+		if done != nil {
+			return done, entry, nil
+		}
+	}
+	if len(opts.Lineage) > 0 {
+		if err := src.addLineage(opts.Lineage, entry.id); err != nil {
+			entry.fail(ctx, conn, err)
+			return nil, entry, err
+		}
+	}
+
+	var res *LoadResult
+	switch {
+	case opts.CommitEvery > 0:
+		res, err = loadCommitting(ctx, conn, src, opts, entry)
+	case opts.Workers > 1:
+		// Parts record in the manifest that they are loaded as they commit.
+		parallel := func(ctx context.Context, tx pgx.Tx, src *Source, opts LoadOptions) (*LoadResult, error) {
+			return parallelAppend(ctx, tx, src, opts, entry)
+		}
+		res, err = loadTx(ctx, conn, src, opts, entry, parallel)
+	default:
+		res, err = loadTx(ctx, conn, src, opts, entry, load)
+	}
+	if err != nil {
+		entry.fail(ctx, conn, err)
+		return nil, entry, err
+	}
+	res.LoadID = entry.id
+	res.Skipped = src.FilterStats()
+	return res, entry, nil
+}
+
+// loadFunc loads the records of a Source within a transaction.
+type loadFunc func(context.Context, pgx.Tx, *Source, LoadOptions) (*LoadResult, error)
+
+// loadFor checks opts and returns the function loading src as they say. A
+// load over several workers needs its manifest entry, so loadAttempt makes
+// its function itself.
+func loadFor(src *Source, opts LoadOptions) (loadFunc, error) {
+	if opts.Deletes != "" && opts.Mode != ModeUpsert {
+		return nil, fmt.Errorf("deleting absent rows needs the %s mode", ModeUpsert)
+	}
+
+	if opts.Workers > 1 && opts.Mode != "" && opts.Mode != ModeAppend {
+		return nil, fmt.Errorf("only the %s mode can load a file over several workers", ModeAppend)
+	}
+	if (opts.CreatePartitions || opts.LeafCopy) && opts.Mode != "" && opts.Mode != ModeAppend {
+		return nil, fmt.Errorf("only the %s mode can load through partitions", ModeAppend)
+	}
+	if opts.CommitEvery > 0 && ((opts.Mode != "" && opts.Mode != ModeAppend) || opts.Workers > 1 || opts.CreatePartitions || opts.LeafCopy) {
+		return nil, fmt.Errorf("only a sequential load in the %s mode can commit every so many records", ModeAppend)
+	}
+
+	var load loadFunc
+	switch opts.Mode {
+	case "", ModeAppend:
+		load = appendRecords
+		if opts.CreatePartitions || opts.LeafCopy {
+			if opts.Workers > 1 {
+				return nil, fmt.Errorf("a partitioned load cannot be split over several workers")
+			}
+			load = partitionedRecords
+		}
+	case ModeUpsert:
+		if err := checkKey(opts.Key, src.Columns()); err != nil {
+			return nil, err
+		}
+		if opts.Deletes != "" && opts.Deletes != DeleteSoft && opts.Deletes != DeleteHard {
+			return nil, fmt.Errorf("unknown delete strategy '%s'", opts.Deletes)
+		}
+		load = upsertRecords
+	case ModeReplace:
+		if opts.Replace != "" && opts.Replace != ReplaceSwap && opts.Replace != ReplaceTruncate {
+			return nil, fmt.Errorf("unknown replace strategy '%s'", opts.Replace)
+		}
+		load = replaceRecords
+	case ModeSCD2:
+		if err := checkKey(opts.Key, src.Columns()); err != nil {
+			return nil, err
+		}
+		if opts.Effective != "" && !contains(src.Columns(), opts.Effective) {
+			return nil, fmt.Errorf("effective date column '%s' is not in the file", opts.Effective)
+		}
+		if contains(opts.Key, opts.Effective) {
+			return nil, fmt.Errorf("effective date column '%s' cannot be part of the key", opts.Effective)
+		}
+		load = scd2Records
+	default:
+		return nil, fmt.Errorf("unknown load mode '%s'", opts.Mode)
+	}
+
+	for _, col := range opts.Lineage {
+		if !contains(LineageColumns, col) {
+			return nil, fmt.Errorf("unknown lineage column '%s'", col)
+		}
+	}
+	return load, nil
+}
+
+func loadTx(ctx context.Context, conn *pgx.Conn, src *Source, opts LoadOptions, entry *manifestEntry, load loadFunc) (*LoadResult, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	res, err := loadInTx(ctx, tx, src, opts, entry, load)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// loadInTx loads src within tx and records it in the manifest as succeeded,
+// which only holds once tx commits.
+func loadInTx(ctx context.Context, tx pgx.Tx, src *Source, opts LoadOptions, entry *manifestEntry, load loadFunc) (*LoadResult, error) {
+	if !opts.Force {
+		if err := entry.checkPrior(ctx, tx); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := load(ctx, tx, src, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := src.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := entry.succeed(ctx, tx, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// loadCommitting appends the records of src in transactions of
+// opts.CommitEvery records, each recording in the manifest how far the load
+// got. Records an earlier attempt committed are read again, for the control
+// totals, but not loaded.
+func loadCommitting(ctx context.Context, conn *pgx.Conn, src *Source, opts LoadOptions, entry *manifestEntry) (*LoadResult, error) {
+	records := &partReader{source: src, after: entry.committed}
+	for {
+		done, err := commitPart(ctx, conn, src, records, opts, entry)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return &LoadResult{Rows: entry.rows, Inserted: entry.rows}, nil
+		}
+	}
+}
+
+// commitPart copies and commits the next opts.CommitEvery records, and
+// reports whether they were the last.
+func commitPart(ctx context.Context, conn *pgx.Conn, src *Source, records *partReader, opts LoadOptions, entry *manifestEntry) (bool, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if entry.committed == 0 && !opts.Force {
+		if err := entry.checkPrior(ctx, tx); err != nil {
+			return false, err
+		}
+	}
+
+	records.limit = opts.CommitEvery
+	n, err := copyRecords(ctx, tx.Conn().PgConn(), opts.Table, src.Columns(), records)
+	if err != nil {
+		return false, err
+	}
+	rows := entry.rows + n
+
+	if records.done {
+		if err := src.Validate(); err != nil {
+			return false, err
+		}
+		if err := entry.succeed(ctx, tx, &LoadResult{Rows: rows, Inserted: rows}); err != nil {
+			return false, err
+		}
+	} else if err := entry.checkpoint(ctx, tx, records.last, rows); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+	entry.committed, entry.rows = records.last, rows
+	return records.done, nil
+}
+
+// partReader reads the records of a source numbered after after, at most
+// limit of them until limit is raised again.
+type partReader struct {
+	source RecordReader
+	after  uint64
+	limit  int
+	last   uint64 // number of the last record read
+	done   bool   // whether the source is exhausted
+}
+
+func (r *partReader) Read() (*Record, error) {
+	if r.limit <= 0 {
+		return nil, io.EOF
+	}
+	for {
+		rec, err := r.source.Read()
+		if err == io.EOF {
+			r.done = true
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rec.RecordNumber <= r.after {
+			continue
+		}
+		r.limit--
+		r.last = rec.RecordNumber
+		return rec, nil
+	}
+}
+
+func appendRecords(ctx context.Context, tx pgx.Tx, src *Source, opts LoadOptions) (*LoadResult, error) {
+	rows, err := copyRecords(ctx, tx.Conn().PgConn(), opts.Table, src.Columns(), src)
+	if err != nil {
+		return nil, err
+	}
+	return &LoadResult{Rows: rows, Inserted: rows}, nil
+}
+
+// checkKey makes sure a key is given and made of loaded columns.
+func checkKey(key []string, columns []string) error {
+	if len(key) == 0 {
+		return fmt.Errorf("no key columns given")
+	}
+	for _, col := range key {
+		if !contains(columns, col) {
+			return fmt.Errorf("key column '%s' is not loaded", col)
+		}
+	}
+	return nil
+}
+
+// copyRecords streams records into table with COPY FROM STDIN.
+func copyRecords(ctx context.Context, conn *pgconn.PgConn, table string, columns []string, records RecordReader) (int64, error) {
+	tag, err := conn.CopyFrom(ctx, NewCopyReader(records, columns), copyFromSQL(table, columns))
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func copyFromSQL(table string, columns []string) string {
+	return "COPY " + quoteTable(table) + " (" + quoteColumns(columns) + ") FROM STDIN"
+}
+
+// quoteTable quotes a table name that may be qualified by its schema.
+func quoteTable(name string) string {
+	return pgx.Identifier(strings.Split(name, ".")).Sanitize()
+}
+
+// quoteColumns quotes column names into a comma separated list.
+func quoteColumns(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = pgx.Identifier{col}.Sanitize()
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// parenthesize wraps a query or condition given by the user in parentheses,
+// dropping a trailing semicolon. On its own line, the closing parenthesis
+// survives a trailing comment.
+func parenthesize(sql string) string {
+	return "(" + strings.TrimRight(strings.TrimSpace(sql), ";") + "\n)"
+}
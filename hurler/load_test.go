@@ -0,0 +1,118 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"context"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jackc/pgx/v4"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testConn connects to the database named by PGHURLER_TEST_DSN, skipping
+// the test if it is not set.
+func testConn(t *testing.T) *pgx.Conn {
+	t.Helper()
+	dsn := os.Getenv("PGHURLER_TEST_DSN")
+	if dsn == "" {
+		t.Skip("PGHURLER_TEST_DSN is not set")
+	}
+	conn, err := pgx.Connect(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	return conn
+}
+
+// testFile writes content to a temporary file named after pattern and
+// returns its path.
+func testFile(t *testing.T, pattern string, content string) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", pattern)
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte(content)); err != nil {
+		t.Fatalf("failed to write temp file: %s", err)
+	}
+	return f.Name()
+}
+
+func TestCopyFromSQL(t *testing.T) {
+
+	tests := map[string]struct {
+		table   string
+		columns []string
+		want    string
+	}{
+		"plain":     {"events", []string{"id", "name"}, `COPY "events" ("id", "name") FROM STDIN`},
+		"schema":    {"staging.events", []string{"id"}, `COPY "staging"."events" ("id") FROM STDIN`},
+		"odd names": {`we"ird`, []string{"Mixed Case"}, `COPY "we""ird" ("Mixed Case") FROM STDIN`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := copyFromSQL(tc.table, tc.columns)
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("copyFromSQL(%q, %q) mismatch (-want +got):\n%s", tc.table, tc.columns, diff)
+			}
+		})
+	}
+}
+
+func TestCheckKey(t *testing.T) {
+	columns := []string{"id", "name"}
+
+	if err := checkKey([]string{"id"}, columns); err != nil {
+		t.Errorf("checkKey() of a loaded column failed: %s", err)
+	}
+	if err := checkKey(nil, columns); err == nil || err.Error() != "no key columns given" {
+		t.Errorf("checkKey() of no columns = %v, want error", err)
+	}
+	if err := checkKey([]string{"id", "region"}, columns); err == nil || err.Error() != "key column 'region' is not loaded" {
+		t.Errorf("checkKey() of a missing column = %v, want error", err)
+	}
+}
+
+func TestPartReader(t *testing.T) {
+	input := "id\n1\n2\n3\n4\n5\n"
+
+	r, err := NewDelimitedReader(strings.NewReader(input), ',')
+	if err != nil {
+		t.Fatalf("NewDelimitedReader() failed: %s", err)
+	}
+
+	// Records 1 and 2 were committed by an earlier attempt.
+	part := &partReader{source: r, after: 2}
+	var got [][]string
+	for !part.done {
+		part.limit = 2
+		var ids []string
+		for {
+			rec, err := part.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Read() failed: %s", err)
+			}
+			ids = append(ids, rec.Values["id"])
+		}
+		got = append(got, ids)
+	}
+
+	if diff := cmp.Diff([][]string{{"3", "4"}, {"5"}}, got); diff != "" {
+		t.Errorf("partReader parts mismatch (-want +got):\n%s", diff)
+	}
+	if part.last != 5 {
+		t.Errorf("partReader last = %d, want 5", part.last)
+	}
+}
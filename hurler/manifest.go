@@ -0,0 +1,249 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"io"
+	"os"
+	"time"
+)
+
+// manifestTable records every load, whether it succeeded or not.
+const manifestTable = "pghurler_loads"
+
+// Load statuses in the manifest.
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+const createManifestSQL = `CREATE TABLE IF NOT EXISTS pghurler_loads (
+	id          bigserial PRIMARY KEY,
+	path        text NOT NULL,
+	size        bigint NOT NULL,
+	sha256      text NOT NULL,
+	target      text NOT NULL,
+	mode        text NOT NULL,
+	rows        bigint,
+	inserted    bigint,
+	updated     bigint,
+	deleted     bigint,
+	committed   bigint,
+	parts       integer[],
+	started_at  timestamptz NOT NULL DEFAULT now(),
+	finished_at timestamptz,
+	status      text NOT NULL,
+	error       text
+)`
+
+const startLoadSQL = `INSERT INTO pghurler_loads (path, size, sha256, target, mode, status)
+VALUES ($1, $2, $3, $4, $5, 'running')
+RETURNING id`
+
+// priorLoadSQL finds an earlier load of the same file into the same table
+// that succeeded, or that committed part of the file without succeeding.
+const priorLoadSQL = `SELECT id, status, coalesce(finished_at, started_at) FROM pghurler_loads
+WHERE sha256 = $1 AND target = $2 AND id <> $3
+	AND (status = 'succeeded' OR coalesce(committed, 0) > 0 OR cardinality(parts) > 0)
+ORDER BY id DESC
+LIMIT 1`
+
+const succeedLoadSQL = `UPDATE pghurler_loads
+SET rows = $2, inserted = $3, updated = $4, deleted = $5, finished_at = clock_timestamp(), status = 'succeeded', error = NULL
+WHERE id = $1`
+
+// checkpointSQL records the part of a load committed so far.
+const checkpointSQL = `UPDATE pghurler_loads
+SET committed = $2, rows = $3, inserted = $3
+WHERE id = $1`
+
+// partSQL records a parallel part of a load as committed.
+const partSQL = `UPDATE pghurler_loads
+SET parts = array_append(coalesce(parts, '{}'), $2::integer), rows = coalesce(rows, 0) + $3,
+	inserted = coalesce(inserted, 0) + $3
+WHERE id = $1`
+
+const progressSQL = `SELECT status, coalesce(committed, 0), coalesce(parts, '{}'), coalesce(rows, 0),
+	coalesce(inserted, 0), coalesce(updated, 0), coalesce(deleted, 0)
+FROM pghurler_loads
+WHERE id = $1`
+
+const resumeLoadSQL = `UPDATE pghurler_loads
+SET finished_at = NULL, status = 'running', error = NULL
+WHERE id = $1`
+
+const failLoadSQL = `UPDATE pghurler_loads
+SET finished_at = clock_timestamp(), status = 'failed', error = $2
+WHERE id = $1`
+
+// AlreadyLoadedError reports that a file with the same content was already
+// loaded into the table, or Partly loaded by a load that committed some of
+// its parts but did not succeed.
+type AlreadyLoadedError struct {
+	Path     string
+	Table    string
+	LoadID   int64
+	LoadedAt time.Time
+	Partly   bool
+}
+
+func (e *AlreadyLoadedError) Error() string {
+	if e.Partly {
+		return fmt.Sprintf("%s was partly loaded into %s by load %d, started at %s, which did not succeed",
+			e.Path, e.Table, e.LoadID, e.LoadedAt.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("%s was already loaded into %s by load %d at %s",
+		e.Path, e.Table, e.LoadID, e.LoadedAt.Format(time.RFC3339))
+}
+
+// manifestEntry is the manifest row of a load in progress.
+type manifestEntry struct {
+	id     int64
+	path   string
+	sha256 string
+	table  string
+
+	// What earlier attempts at the load committed: the number of the
+	// last record committed, and the rows loaded.
+	committed uint64
+	rows      int64
+
+	// The parallel parts earlier attempts committed, numbered from 0.
+	parts []int
+}
+
+// startManifest creates the manifest table if needed and records the load
+// as running. The row is committed straight away so that a load which fails
+// is recorded too.
+func startManifest(ctx context.Context, conn *pgx.Conn, path string, opts LoadOptions) (*manifestEntry, error) {
+	size, sum, err := hashFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Exec(ctx, createManifestSQL); err != nil {
+		return nil, fmt.Errorf("creating %s: %s", manifestTable, err)
+	}
+
+	mode := opts.Mode
+	if mode == "" {
+		mode = ModeAppend
+	}
+	e := &manifestEntry{path: path, sha256: sum, table: opts.Table}
+	if err := conn.QueryRow(ctx, startLoadSQL, path, size, sum, opts.Table, mode).Scan(&e.id); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// checkPrior refuses a file already loaded into the table, even in part.
+// The advisory lock, held until the transaction ends, keeps two loads of
+// the same file from both passing the check.
+func (e *manifestEntry) checkPrior(ctx context.Context, tx pgx.Tx) error {
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1::text || $2::text))", e.sha256, e.table); err != nil {
+		return err
+	}
+
+	var id int64
+	var status string
+	var at time.Time
+	err := tx.QueryRow(ctx, priorLoadSQL, e.sha256, e.table, e.id).Scan(&id, &status, &at)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return &AlreadyLoadedError{Path: e.path, Table: e.table, LoadID: id, LoadedAt: at, Partly: status != StatusSucceeded}
+}
+
+// succeed records the load's counts within its transaction, so the manifest
+// only says succeeded once the data is committed.
+func (e *manifestEntry) succeed(ctx context.Context, tx pgx.Tx, res *LoadResult) error {
+	_, err := tx.Exec(ctx, succeedLoadSQL, e.id, res.Rows, res.Inserted, res.Updated, res.Deleted)
+	return err
+}
+
+// checkpoint records, within the transaction committing them, that the
+// records up to the one numbered committed are loaded, rows in all.
+func (e *manifestEntry) checkpoint(ctx context.Context, tx pgx.Tx, committed uint64, rows int64) error {
+	_, err := tx.Exec(ctx, checkpointSQL, e.id, int64(committed), rows)
+	return err
+}
+
+// commitPart records, within the transaction committing it, that the
+// parallel part numbered part is loaded, rows in all, and commits it.
+func (e *manifestEntry) commitPart(ctx context.Context, tx pgx.Tx, part int, rows int64) error {
+	if _, err := tx.Exec(ctx, partSQL, e.id, part, rows); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	e.parts = append(e.parts, part)
+	e.rows += rows
+	return nil
+}
+
+// partLoaded reports whether an earlier attempt committed the parallel part
+// numbered part.
+func (e *manifestEntry) partLoaded(part int) bool {
+	for _, p := range e.parts {
+		if p == part {
+			return true
+		}
+	}
+	return false
+}
+
+// resume prepares another attempt at the load, reading what earlier ones
+// committed and marking it running again. If an earlier attempt succeeded
+// after all, its commit having been lost with the connection, it returns
+// that attempt's result.
+func (e *manifestEntry) resume(ctx context.Context, conn *pgx.Conn) (*LoadResult, error) {
+	var status string
+	var committed int64
+	var parts []int
+	res := &LoadResult{LoadID: e.id}
+	err := conn.QueryRow(ctx, progressSQL, e.id).Scan(&status, &committed, &parts, &res.Rows, &res.Inserted, &res.Updated, &res.Deleted)
+	if err != nil {
+		return nil, err
+	}
+	if status == StatusSucceeded {
+		return res, nil
+	}
+	e.committed, e.parts, e.rows = uint64(committed), parts, res.Rows
+	if _, err := conn.Exec(ctx, resumeLoadSQL, e.id); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// fail records why the load failed, after its transaction rolled back.
+func (e *manifestEntry) fail(ctx context.Context, conn *pgx.Conn, loadErr error) {
+	// The load's own error matters more than one recording it.
+	conn.Exec(ctx, failLoadSQL, e.id, loadErr.Error())
+}
+
+// hashFile gives the size and hex SHA-256 of the file at path.
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,55 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHashFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "*.csv")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err)
+	}
+	defer os.Remove(f.Name()) // clean up
+	if _, err := f.Write([]byte("id,name\n1,ada\n")); err != nil {
+		t.Fatalf("failed to write temp file: %s", err)
+	}
+	f.Close()
+
+	size, sum, err := hashFile(f.Name())
+	if err != nil {
+		t.Fatalf("hashFile() failed: %s", err)
+	}
+	if size != 14 {
+		t.Errorf("hashFile() size = %d, want 14", size)
+	}
+	want := "9b3b4f0a200b3be4cc5c032abb8af321bf7643227b07c8171694cb3688095da6"
+	if sum != want {
+		t.Errorf("hashFile() sum = %s, want %s", sum, want)
+	}
+
+	if _, _, err := hashFile(f.Name() + ".missing"); err == nil {
+		t.Errorf("hashFile() of a missing file succeeded")
+	}
+}
+
+func TestAlreadyLoadedError(t *testing.T) {
+	err := &AlreadyLoadedError{Path: "a.csv", Table: "events", LoadID: 12, LoadedAt: time.Date(2019, 6, 1, 8, 30, 0, 0, time.UTC)}
+
+	want := "a.csv was already loaded into events by load 12 at 2019-06-01T08:30:00Z"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+
+	err.Partly = true
+	want = "a.csv was partly loaded into events by load 12, started at 2019-06-01T08:30:00Z, which did not succeed"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
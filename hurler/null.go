@@ -0,0 +1,40 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+// NullRules decide which field values are read as NULL rather than as
+// strings. A marker of "" makes empty fields NULL.
+type NullRules struct {
+	// Markers are the values treated as NULL in every column, e.g. "",
+	// `\N`, "NULL", "N/A" or "-".
+	Markers []string
+
+	// Columns overrides Markers for individual columns. A column mapped to
+	// an empty slice never has NULL values.
+	Columns map[string][]string
+
+	// MatchQuoted applies the markers to quoted fields as well. By default
+	// a quoted field is always a literal value, so "" is an empty string
+	// while an unquoted empty field may be NULL.
+	MatchQuoted bool
+}
+
+// IsNull reports whether value, read from column, is NULL under the rules.
+func (n *NullRules) IsNull(column string, value string, quoted bool) bool {
+	if quoted && !n.MatchQuoted {
+		return false
+	}
+
+	markers, ok := n.Columns[column]
+	if !ok {
+		markers = n.Markers
+	}
+	for _, m := range markers {
+		if value == m {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"strings"
+	"testing"
+)
+
+func TestNullRulesIsNull(t *testing.T) {
+
+	rules := &NullRules{
+		Markers: []string{"", `\N`, "NULL"},
+		Columns: map[string][]string{
+			"amount": {"-", "N/A"},
+			"code":   {},
+		},
+	}
+
+	tests := map[string]struct {
+		rules  *NullRules
+		column string
+		value  string
+		quoted bool
+		want   bool
+	}{
+		"unquoted empty":          {rules, "name", "", false, true},
+		"quoted empty":            {rules, "name", "", true, false},
+		"global marker":           {rules, "name", `\N`, false, true},
+		"not a marker":            {rules, "name", "N/A", false, false},
+		"column marker":           {rules, "amount", "N/A", false, true},
+		"column overrides global": {rules, "amount", "NULL", false, false},
+		"column without markers":  {rules, "code", "", false, false},
+		"quoted marker":           {rules, "name", "NULL", true, false},
+		"match quoted":            {&NullRules{Markers: []string{""}, MatchQuoted: true}, "name", "", true, true},
+		"no rules":                {&NullRules{}, "name", "", false, false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := tc.rules.IsNull(tc.column, tc.value, tc.quoted)
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("IsNull(%q, %q, %v) mismatch (-want +got):\n%s", tc.column, tc.value, tc.quoted, diff)
+			}
+		})
+	}
+}
+
+func TestReadNulls(t *testing.T) {
+
+	tests := map[string]struct {
+		input string
+		rules *NullRules
+		want  []*Record
+	}{
+		"no rules": {
+			"col1,col2\n,\"\"",
+			nil,
+			[]*Record{
+				{LineNumber: 2, RecordNumber: 1, Values: map[string]string{"col1": "", "col2": ""}},
+			},
+		},
+		"empty marker": {
+			"col1,col2\n,\"\"",
+			&NullRules{Markers: []string{""}},
+			[]*Record{
+				{LineNumber: 2, RecordNumber: 1, Values: map[string]string{"col1": "", "col2": ""}, Nulls: map[string]bool{"col1": true}},
+			},
+		},
+		"marker value is blanked": {
+			"col1,col2\nN/A,val2",
+			&NullRules{Markers: []string{"N/A"}},
+			[]*Record{
+				{LineNumber: 2, RecordNumber: 1, Values: map[string]string{"col1": "", "col2": "val2"}, Nulls: map[string]bool{"col1": true}},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r, err := NewDelimitedReader(strings.NewReader(tc.input), ',')
+			if err != nil {
+				t.Fatalf("NewDelimitedReader() failed: %s", err)
+			}
+			r.NullRules = tc.rules
+
+			recs, err := r.ReadAll()
+			if err != nil {
+				t.Fatalf("ReadAll() failed: %s", err)
+			}
+
+			if diff := cmp.Diff(tc.want, recs); diff != "" {
+				t.Errorf("ReadAll() Records mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
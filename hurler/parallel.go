@@ -0,0 +1,289 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"io"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// chunk is a byte range of a file holding whole records, with the counts
+// needed to carry line and record numbers on from the chunks before it.
+type chunk struct {
+	start  int64
+	end    int64
+	line   uint64 // physical lines before start
+	record uint64 // data records before start
+}
+
+// rowSplitter cuts its input into rows, each a record with its terminating
+// newline, without parsing their fields. Like scanner it only opens a quoted
+// section at the start of a field, so newlines within quotes do not end a
+// row.
+type rowSplitter struct {
+	reader *bufio.Reader
+	comma  []byte
+	offset int64  // bytes consumed
+	line   uint64 // physical lines consumed
+	row    []byte
+}
+
+func newRowSplitter(r io.Reader, comma rune) *rowSplitter {
+	buf := make([]byte, utf8.RuneLen(comma))
+	utf8.EncodeRune(buf, comma)
+	return &rowSplitter{reader: bufio.NewReaderSize(r, 1<<20), comma: buf}
+}
+
+// next returns the next row and the line it starts on. The row is only
+// valid until the following call.
+func (s *rowSplitter) next() ([]byte, uint64, error) {
+	s.row = s.row[:0]
+	start := s.line + 1
+	inQuotes, closing := false, false
+
+	for {
+		piece, err := s.reader.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull && err != io.EOF {
+			return nil, 0, err
+		}
+		from := len(s.row)
+		s.row = append(s.row, piece...)
+		s.offset += int64(len(piece))
+
+		for j := from; j < len(s.row); j++ {
+			c := s.row[j]
+			if closing {
+				closing = false
+				if c == '"' {
+					continue // a doubled quote, still quoted
+				}
+				inQuotes = false
+			}
+			switch {
+			case inQuotes:
+				closing = c == '"'
+			case c == '"' && (j == 0 || bytes.HasSuffix(s.row[:j], s.comma)):
+				inQuotes = true
+			}
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && len(s.row) == 0 {
+			return nil, 0, io.EOF
+		}
+		s.line++
+		if err == io.EOF || !inQuotes {
+			return s.row, start, nil
+		}
+	}
+}
+
+// splitFile cuts the records of a file of the given size into at most n
+// chunks of about the same size. The records follow the column header, which
+// starts on line header. Control records are not counted as records, as
+// Reader leaves them out.
+func splitFile(r io.Reader, size int64, header uint64, n int, comma rune, controls *Controls) ([]chunk, error) {
+	s := newRowSplitter(r, comma)
+	for {
+		row, start, err := s.next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("file ends before its header on line %d", header)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if start >= header && !isBlank(row) {
+			break
+		}
+	}
+
+	chunks := []chunk{{start: s.offset, line: s.line}}
+	target := (size - s.offset) / int64(n)
+	var records uint64
+	for {
+		row, _, err := s.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !isBlank(row) && !isControl(row, comma, controls) {
+			records++
+		}
+
+		last := &chunks[len(chunks)-1]
+		if len(chunks) < n && s.offset-last.start >= target && s.offset < size {
+			last.end = s.offset
+			chunks = append(chunks, chunk{start: s.offset, line: s.line, record: records})
+		}
+	}
+	chunks[len(chunks)-1].end = s.offset
+	return chunks, nil
+}
+
+func isBlank(row []byte) bool {
+	return len(bytes.TrimRight(row, "\r\n")) == 0
+}
+
+// isControl reports whether row is a control record, without recording it.
+func isControl(row []byte, comma rune, controls *Controls) bool {
+	if len(controls.specs) == 0 {
+		return false
+	}
+	text := strings.TrimRight(string(row), "\r\n")
+	if bytes.IndexByte(row, '"') >= 0 {
+		raw, err := newScanner(bytes.NewReader(row), comma).readRecord()
+		if err != nil {
+			return false
+		}
+		text = strings.Join(raw.fields, string(comma))
+	}
+	return controls.matches(text, false) >= 0
+}
+
+// newChunkReader returns a Reader of the records of a chunk, which follow
+// the column header, numbering lines and records on from the chunk's.
+func newChunkReader(r io.Reader, comma rune, columns []string, c chunk) *Reader {
+	s := newScanner(r, comma)
+	s.line = c.line
+	return &Reader{source: s, comma: comma, columns: columns, currentLine: c.line, currentRecord: c.record}
+}
+
+// Split divides the source's records into at most n parts of about the same
+// size, which may be read concurrently. Each part numbers its lines and
+// records as the whole file does, and they share the source's filter
+// counts and control totals. The source itself must not be read as well.
+func (s *Source) Split(n int) ([]RecordReader, error) {
+	if s.gzip != nil {
+		return nil, fmt.Errorf("a compressed file cannot be split")
+	}
+	info, err := s.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// Reading at an offset leaves the file's own position alone.
+	chunks, err := splitFile(io.NewSectionReader(s.file, 0, info.Size()), info.Size(), s.header.currentLine, n, s.delimiter, s.controls)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]RecordReader, len(chunks))
+	for i, c := range chunks {
+		reader := newChunkReader(io.NewSectionReader(s.file, c.start, c.end-c.start), s.delimiter, s.header.Columns(), c)
+		reader.NullRules = s.nulls
+		reader.controls = s.controls
+		parts[i] = s.pipeline(reader)
+	}
+	return parts, nil
+}
+
+// parallelAppend copies the parts of src over opts.Workers connections, the
+// first being tx's own. The other connections commit once every part is
+// loaded and the control totals check out, just before tx does, each
+// recording in the manifest that its part is loaded. Parts an earlier
+// attempt committed are read again, for the control totals, but not loaded.
+func parallelAppend(ctx context.Context, tx pgx.Tx, src *Source, opts LoadOptions, entry *manifestEntry) (*LoadResult, error) {
+	parts, err := src.Split(opts.Workers)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// txs[i] loads part i, or is nil if the part is already loaded.
+	txs := make([]pgx.Tx, len(parts))
+	txs[0] = tx
+	defer func() {
+		for _, t := range txs[1:] {
+			if t != nil {
+				t.Rollback(context.Background())
+				t.Conn().Close(context.Background())
+			}
+		}
+	}()
+	for i := 1; i < len(parts); i++ {
+		if entry.partLoaded(i) {
+			continue
+		}
+		conn, err := pgx.ConnectConfig(ctx, tx.Conn().Config())
+		if err != nil {
+			return nil, err
+		}
+		t, err := conn.Begin(ctx)
+		if err != nil {
+			conn.Close(ctx)
+			return nil, err
+		}
+		txs[i] = t
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		rows     = make([]int64, len(parts))
+		firstErr error
+	)
+	for i, part := range parts {
+		wg.Add(1)
+		go func(i int, t pgx.Tx, part RecordReader) {
+			defer wg.Done()
+			var n int64
+			var err error
+			if t != nil {
+				n, err = copyRecords(ctx, t.Conn().PgConn(), opts.Table, src.Columns(), part)
+			} else {
+				err = skipRecords(part)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+				cancel() // stop the other parts
+			}
+			rows[i] = n
+		}(i, txs[i], part)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	if err := src.Validate(); err != nil {
+		return nil, err
+	}
+	for i, t := range txs[1:] {
+		if t == nil {
+			continue
+		}
+		if err := entry.commitPart(ctx, t, i+1, rows[i+1]); err != nil {
+			return nil, fmt.Errorf("committing part %d of %d: %w", i+2, len(parts), err)
+		}
+	}
+	total := entry.rows + rows[0]
+	return &LoadResult{Rows: total, Inserted: total}, nil
+}
+
+// skipRecords reads the records of r to the end without loading them.
+func skipRecords(r RecordReader) error {
+	for {
+		if _, err := r.Read(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
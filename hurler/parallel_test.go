@@ -0,0 +1,230 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRowSplitter(t *testing.T) {
+	in := "a,b\r\n\"x\ny\",\"say \"\"hi\"\"\n\"\n\nz,w\"q\nlast"
+
+	s := newRowSplitter(strings.NewReader(in), ',')
+	var rows []string
+	var starts []uint64
+	for {
+		row, start, err := s.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("next() failed: %s", err)
+		}
+		rows = append(rows, string(row))
+		starts = append(starts, start)
+	}
+
+	wantRows := []string{"a,b\r\n", "\"x\ny\",\"say \"\"hi\"\"\n\"\n", "\n", "z,w\"q\n", "last"}
+	if diff := cmp.Diff(wantRows, rows); diff != "" {
+		t.Errorf("rows mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]uint64{1, 2, 5, 6, 7}, starts); diff != "" {
+		t.Errorf("start lines mismatch (-want +got):\n%s", diff)
+	}
+	if s.line != 7 || s.offset != int64(len(in)) {
+		t.Errorf("splitter consumed %d lines and %d bytes, want 7 and %d", s.line, s.offset, len(in))
+	}
+}
+
+func TestSourceSplit(t *testing.T) {
+	f, err := ioutil.TempFile("", "*.csv")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err)
+	}
+	defer os.Remove(f.Name()) // clean up
+
+	var b strings.Builder
+	b.WriteString("HDR,2019-06-01\nid,name,amount\n")
+	for i := 1; i <= 40; i++ {
+		switch {
+		case i%7 == 0:
+			fmt.Fprintf(&b, "%d,\"line\none, \"\"quoted\"\"\",%d\n", i, i)
+		case i%11 == 0:
+			fmt.Fprintf(&b, "\n%d,skip,%d\n", i, i)
+		default:
+			fmt.Fprintf(&b, "%d,name %d,%d\n", i, i, i)
+		}
+	}
+	b.WriteString("TRL,40,820\n")
+	if _, err := f.Write([]byte(b.String())); err != nil {
+		t.Fatalf("failed to write temp file: %s", err)
+	}
+	f.Close()
+
+	opts := SourceOptions{
+		Controls: []ControlSpec{
+			{Kind: "header", Pattern: `^HDR,`},
+			{Kind: "trailer", Pattern: `^TRL,`, Count: 2, Sums: []ControlSum{{Field: 3, Column: "amount"}}},
+		},
+		Skip: []string{"name = 'skip'"},
+	}
+
+	read := func(r RecordReader) []*Record {
+		var recs []*Record
+		for {
+			rec, err := r.Read()
+			if err == io.EOF {
+				return recs
+			}
+			if err != nil {
+				t.Fatalf("Read() failed: %s", err)
+			}
+			recs = append(recs, rec)
+		}
+	}
+
+	src, err := OpenSource(f.Name(), opts)
+	if err != nil {
+		t.Fatalf("OpenSource() failed: %s", err)
+	}
+	want := read(src)
+	src.Close()
+
+	for n := 1; n <= 6; n++ {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			src, err := OpenSource(f.Name(), opts)
+			if err != nil {
+				t.Fatalf("OpenSource() failed: %s", err)
+			}
+			defer src.Close()
+
+			parts, err := src.Split(n)
+			if err != nil {
+				t.Fatalf("Split() failed: %s", err)
+			}
+			if len(parts) != n {
+				t.Errorf("Split(%d) gave %d parts", n, len(parts))
+			}
+
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			var got []*Record
+			for _, part := range parts {
+				wg.Add(1)
+				go func(part RecordReader) {
+					defer wg.Done()
+					recs := read(part)
+					mu.Lock()
+					got = append(got, recs...)
+					mu.Unlock()
+				}(part)
+			}
+			wg.Wait()
+
+			sort.Slice(got, func(i, j int) bool { return got[i].RecordNumber < got[j].RecordNumber })
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("parts' records mismatch (-want +got):\n%s", diff)
+			}
+			if err := src.Validate(); err != nil {
+				t.Errorf("Validate() failed: %s", err)
+			}
+			if diff := cmp.Diff([]FilterStat{{Rule: "skip name = 'skip'", Skipped: 3}}, src.FilterStats()); diff != "" {
+				t.Errorf("FilterStats() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParallelAppendResume(t *testing.T) {
+	ctx := context.Background()
+	conn := testConn(t)
+	defer conn.Close(ctx)
+
+	if _, err := conn.Exec(ctx, "CREATE TABLE pghurler_test_parallel (id int PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %s", err)
+	}
+	defer conn.Exec(ctx, "DROP TABLE pghurler_test_parallel") // clean up
+
+	var b strings.Builder
+	b.WriteString("id\n")
+	for i := 1; i <= 20; i++ {
+		fmt.Fprintf(&b, "%d\n", i)
+	}
+	path := testFile(t, "*.csv", b.String())
+	defer os.Remove(path) // clean up
+	opts := LoadOptions{Table: "pghurler_test_parallel", Workers: 2}
+
+	load := func(entry *manifestEntry) (*LoadResult, *manifestEntry, error) {
+		src, err := OpenSource(path, SourceOptions{})
+		if err != nil {
+			t.Fatalf("OpenSource() failed: %s", err)
+		}
+		defer src.Close()
+		return loadAttempt(ctx, conn, src, opts, entry)
+	}
+	_, entry, err := load(nil)
+	if err != nil {
+		t.Fatalf("loading failed: %s", err)
+	}
+	defer conn.Exec(ctx, "DELETE FROM pghurler_loads WHERE sha256 = $1", entry.sha256) // clean up
+
+	// Undo the first part, as if the load's own transaction had failed
+	// after the second part committed.
+	src, err := OpenSource(path, SourceOptions{})
+	if err != nil {
+		t.Fatalf("OpenSource() failed: %s", err)
+	}
+	parts, err := src.Split(2)
+	if err != nil {
+		t.Fatalf("Split() failed: %s", err)
+	}
+	var first []string
+	for {
+		rec, err := parts[0].Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read() failed: %s", err)
+		}
+		first = append(first, rec.Values["id"])
+	}
+	src.Close()
+	if _, err := conn.Exec(ctx, "DELETE FROM pghurler_test_parallel WHERE id::text = ANY($1)", first); err != nil {
+		t.Fatalf("failed to delete the first part: %s", err)
+	}
+	_, err = conn.Exec(ctx, `UPDATE pghurler_loads SET status = 'failed', rows = rows - $2, inserted = inserted - $2
+		WHERE id = $1`, entry.id, len(first))
+	if err != nil {
+		t.Fatalf("failed to fail the load: %s", err)
+	}
+
+	var loaded *AlreadyLoadedError
+	if _, _, err := load(nil); !errors.As(err, &loaded) || !loaded.Partly {
+		t.Errorf("loading the partly loaded file again gave %v, want an *AlreadyLoadedError", err)
+	}
+
+	res, _, err := load(&manifestEntry{id: entry.id, path: entry.path, sha256: entry.sha256, table: entry.table})
+	if err != nil {
+		t.Fatalf("resuming the load failed: %s", err)
+	}
+	var count int
+	if err := conn.QueryRow(ctx, "SELECT count(*) FROM pghurler_test_parallel").Scan(&count); err != nil {
+		t.Fatalf("counting rows failed: %s", err)
+	}
+	if res.Rows != 20 || count != 20 {
+		t.Errorf("resumed load gave %d rows and left %d in the table, want 20", res.Rows, count)
+	}
+}
@@ -0,0 +1,357 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Intervals of the range partitions created for new key values.
+const (
+	PartitionDay   = "day"
+	PartitionMonth = "month"
+	PartitionYear  = "year"
+)
+
+// partitionKeySQL finds the strategy and key of a table partitioned on a
+// single column.
+const partitionKeySQL = `SELECT p.partstrat, a.attname, format_type(a.atttypid, a.atttypmod)
+FROM pg_partitioned_table p
+JOIN pg_attribute a ON a.attrelid = p.partrelid AND a.attnum = p.partattrs[0]
+WHERE p.partrelid = $1::regclass AND p.partnatts = 1`
+
+// partitioning is how the target table is partitioned.
+type partitioning struct {
+	strategy string // "r" for range, "l" for list
+	column   string
+	keyType  string         // as format_type gives it
+	location *time.Location // the session's, for timestamptz keys
+	interval string
+}
+
+// partition is where a group of records goes: the leaf named by suffix,
+// created with bound if missing.
+type partition struct {
+	suffix string
+	bound  string // FOR VALUES clause; empty if only the parent can take them
+}
+
+// partitionedRecords loads the records through the target's leaf
+// partitions. Records are first spilled to a temporary file per partition,
+// so that the partitions their key values need are known, and created if
+// opts.CreatePartitions is set, before anything is copied. With
+// opts.LeafCopy each file is then copied straight into its leaf, sparing
+// the server the routing of every row; otherwise all go through the
+// parent.
+func partitionedRecords(ctx context.Context, tx pgx.Tx, src *Source, opts LoadOptions) (*LoadResult, error) {
+	p, err := lookupPartitioning(ctx, tx, opts.Table)
+	if err != nil {
+		return nil, err
+	}
+	p.interval = opts.PartitionInterval
+	if p.interval == "" {
+		p.interval = PartitionMonth
+	}
+	if !contains(src.Columns(), p.column) {
+		return nil, fmt.Errorf("partition key column '%s' is not loaded", p.column)
+	}
+	if p.strategy == "r" {
+		// Any date checks the key type and interval before a record is read.
+		if _, err := p.rangeOf("2000-01-01"); err != nil {
+			return nil, err
+		}
+	}
+
+	spills, err := spillRecords(src, src.Columns(), p)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, s := range spills {
+			os.Remove(s.file.Name())
+			s.file.Close()
+		}
+	}()
+
+	// Spills are loaded in the order of their partitions.
+	var parts []partition
+	for part := range spills {
+		parts = append(parts, part)
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].suffix < parts[j].suffix })
+
+	schema, name := splitTable(opts.Table)
+	res := &LoadResult{}
+	for _, part := range parts {
+		target := opts.Table
+		if part.bound != "" {
+			// Postgres would truncate a long name, so that two leaves
+			// could end up sharing it.
+			leaf := joinTable(schema, shortIdentifier(name+"_"+part.suffix))
+			ok, err := isPartition(ctx, tx, leaf, opts.Table)
+			if err != nil {
+				return nil, err
+			}
+			if !ok && opts.CreatePartitions {
+				if ok, err = createPartition(ctx, tx, opts.Table, leaf, part.bound); err != nil {
+					return nil, err
+				}
+			}
+			if ok && opts.LeafCopy {
+				target = leaf
+			}
+		}
+
+		f := spills[part].file
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		tag, err := tx.Conn().PgConn().CopyFrom(ctx, f, copyFromSQL(target, src.Columns()))
+		if err != nil {
+			return nil, fmt.Errorf("copying into %s: %s", target, err)
+		}
+		res.Rows += tag.RowsAffected()
+	}
+	res.Inserted = res.Rows
+	return res, nil
+}
+
+func lookupPartitioning(ctx context.Context, tx pgx.Tx, table string) (*partitioning, error) {
+	p := &partitioning{location: time.UTC}
+	err := tx.QueryRow(ctx, partitionKeySQL, quoteTable(table)).Scan(&p.strategy, &p.column, &p.keyType)
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("%s is not partitioned on a single column", table)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.strategy != "r" && p.strategy != "l" {
+		return nil, fmt.Errorf("%s is hash partitioned; only range and list partitions are supported", table)
+	}
+
+	if p.keyType == "timestamp with time zone" {
+		var zone string
+		if err := tx.QueryRow(ctx, "SELECT current_setting('TimeZone')").Scan(&zone); err != nil {
+			return nil, err
+		}
+		if loc, err := time.LoadLocation(zone); err == nil {
+			p.location = loc
+		}
+	}
+	return p, nil
+}
+
+// spill is the temporary file of the records of one partition.
+type spill struct {
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// spillRecords writes the records of src, in COPY text format, to a
+// temporary file per partition.
+func spillRecords(src RecordReader, columns []string, p *partitioning) (map[partition]*spill, error) {
+	spills := make(map[partition]*spill)
+	fail := func(err error) (map[partition]*spill, error) {
+		for _, s := range spills {
+			os.Remove(s.file.Name())
+			s.file.Close()
+		}
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for {
+		rec, err := src.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+		part, err := p.partitionOf(rec)
+		if err != nil {
+			return fail(err)
+		}
+
+		s := spills[part]
+		if s == nil {
+			f, err := ioutil.TempFile("", "pghurler-*.copy")
+			if err != nil {
+				return fail(err)
+			}
+			s = &spill{file: f, writer: bufio.NewWriter(f)}
+			spills[part] = s
+		}
+		buf.Reset()
+		writeCopyRecord(&buf, columns, rec)
+		if _, err := s.writer.Write(buf.Bytes()); err != nil {
+			return fail(err)
+		}
+	}
+
+	for _, s := range spills {
+		if err := s.writer.Flush(); err != nil {
+			return fail(err)
+		}
+	}
+	return spills, nil
+}
+
+// partitionOf returns the partition the key value of rec belongs in.
+func (p *partitioning) partitionOf(rec *Record) (partition, error) {
+	value := rec.Values[p.column]
+	if p.strategy == "l" {
+		if rec.IsNull(p.column) {
+			return partition{suffix: "null", bound: "FOR VALUES IN (NULL)"}, nil
+		}
+		return partition{suffix: partitionSuffix(value), bound: "FOR VALUES IN (" + quoteLiteral(value) + ")"}, nil
+	}
+
+	if rec.IsNull(p.column) {
+		// Only a default partition takes NULL range keys.
+		return partition{suffix: "null"}, nil
+	}
+	part, err := p.rangeOf(value)
+	if err == errNotISO {
+		return partition{}, &ConversionError{Column: p.column, LineNumber: rec.LineNumber, Value: value, Type: p.keyType, Err: err}
+	}
+	return part, err
+}
+
+// keyLayouts are the layouts range keys are read in, those the type
+// conversions write among them.
+var keyLayouts = []string{
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+var errNotISO = errors.New("not an ISO 8601 date or timestamp")
+
+// rangeOf returns the range partition holding value.
+func (p *partitioning) rangeOf(value string) (partition, error) {
+	var layout string
+	switch p.keyType {
+	case "date":
+		layout = "2006-01-02"
+	case "timestamp without time zone":
+		layout = "2006-01-02 15:04:05"
+	case "timestamp with time zone":
+		layout = "2006-01-02 15:04:05-07:00"
+	default:
+		return partition{}, fmt.Errorf("range partitions of %s keys are not supported", p.keyType)
+	}
+
+	var t time.Time
+	var err error
+	value = strings.TrimSpace(value)
+	for _, l := range keyLayouts {
+		loc := time.UTC
+		if p.keyType == "timestamp with time zone" {
+			loc = p.location
+		}
+		if t, err = time.ParseInLocation(l, value, loc); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return partition{}, errNotISO
+	}
+	if p.keyType == "timestamp with time zone" {
+		t = t.In(p.location)
+	}
+
+	y, m, d := t.Date()
+	var lo, hi time.Time
+	var suffix string
+	switch p.interval {
+	case PartitionDay:
+		lo = time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+		hi = lo.AddDate(0, 0, 1)
+		suffix = lo.Format("2006_01_02")
+	case PartitionMonth:
+		lo = time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+		hi = lo.AddDate(0, 1, 0)
+		suffix = lo.Format("2006_01")
+	case PartitionYear:
+		lo = time.Date(y, 1, 1, 0, 0, 0, 0, t.Location())
+		hi = lo.AddDate(1, 0, 0)
+		suffix = lo.Format("2006")
+	default:
+		return partition{}, fmt.Errorf("unknown partition interval '%s'", p.interval)
+	}
+	return partition{suffix: suffix, bound: "FOR VALUES FROM (" + quoteLiteral(lo.Format(layout)) + ") TO (" + quoteLiteral(hi.Format(layout)) + ")"}, nil
+}
+
+// isPartition reports whether leaf exists as a partition of table.
+func isPartition(ctx context.Context, tx pgx.Tx, leaf string, table string) (bool, error) {
+	var ok bool
+	err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_inherits WHERE inhrelid = to_regclass($1) AND inhparent = $2::regclass)",
+		quoteTable(leaf), quoteTable(table)).Scan(&ok)
+	return ok, err
+}
+
+// createPartition creates leaf as a partition of table. It reports false if
+// another partition already covers the bounds, in which case the rows are
+// left to the parent to route.
+func createPartition(ctx context.Context, tx pgx.Tx, table string, leaf string, bound string) (bool, error) {
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	_, err = sp.Exec(ctx, "CREATE TABLE "+quoteTable(leaf)+" PARTITION OF "+quoteTable(table)+" "+bound)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "42P17" { // overlapping partition
+		return false, sp.Rollback(ctx)
+	}
+	if err != nil {
+		sp.Rollback(ctx)
+		return false, fmt.Errorf("creating partition %s: %s", leaf, err)
+	}
+	return true, sp.Commit(ctx)
+}
+
+var nonIdentifier = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// partitionSuffix turns a list value into part of a table name. Values
+// that do not make one as they are get a hash of themselves appended, so
+// that no two share a suffix.
+func partitionSuffix(value string) string {
+	s := strings.Trim(nonIdentifier.ReplaceAllString(strings.ToLower(value), "_"), "_")
+	if s == value && s != "null" {
+		return s
+	}
+	if len(s) > 40 {
+		s = s[:40]
+	}
+	sum := sha256.Sum256([]byte(value))
+	if s == "" {
+		return hex.EncodeToString(sum[:4])
+	}
+	return s + "_" + hex.EncodeToString(sum[:4])
+}
+
+// quoteLiteral quotes s as an SQL string literal.
+func quoteLiteral(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
@@ -0,0 +1,136 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPartitionOfRange(t *testing.T) {
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skipf("no time zone data: %s", err)
+	}
+
+	tests := map[string]struct {
+		keyType  string
+		interval string
+		value    string
+		want     partition
+		err      string
+	}{
+		"date by month": {"date", PartitionMonth, "2019-12-31", partition{"2019_12", "FOR VALUES FROM ('2019-12-01') TO ('2020-01-01')"}, ""},
+		"date by day":   {"date", PartitionDay, "2019-02-28", partition{"2019_02_28", "FOR VALUES FROM ('2019-02-28') TO ('2019-03-01')"}, ""},
+		"date by year":  {"date", PartitionYear, "2019-06-01", partition{"2019", "FOR VALUES FROM ('2019-01-01') TO ('2020-01-01')"}, ""},
+		"timestamp":     {"timestamp without time zone", PartitionMonth, "2019-06-30 23:59:59.5", partition{"2019_06", "FOR VALUES FROM ('2019-06-01 00:00:00') TO ('2019-07-01 00:00:00')"}, ""},
+		"timestamptz in session zone": {"timestamp with time zone", PartitionMonth, "2019-06-30 23:30:00+00:00",
+			partition{"2019_07", "FOR VALUES FROM ('2019-07-01 00:00:00+02:00') TO ('2019-08-01 00:00:00+02:00')"}, ""},
+		"not a date":       {"date", PartitionMonth, "06/01/2019", partition{}, `line 2, column "key": cannot convert "06/01/2019" to date: not an ISO 8601 date or timestamp`},
+		"unknown interval": {"date", "week", "2019-06-01", partition{}, "unknown partition interval 'week'"},
+		"unsupported type": {"integer", PartitionMonth, "12", partition{}, "range partitions of integer keys are not supported"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := &partitioning{strategy: "r", column: "key", keyType: tc.keyType, location: amsterdam, interval: tc.interval}
+			got, err := p.partitionOf(&Record{LineNumber: 2, Values: map[string]string{"key": tc.value}})
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Errorf("partitionOf(%q) error = %v, want %q", tc.value, err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("partitionOf(%q) failed: %s", tc.value, err)
+			}
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(partition{})); diff != "" {
+				t.Errorf("partitionOf(%q) mismatch (-want +got):\n%s", tc.value, diff)
+			}
+		})
+	}
+}
+
+func TestPartitionOfList(t *testing.T) {
+	p := &partitioning{strategy: "l", column: "region"}
+
+	rec := &Record{Values: map[string]string{"region": "eu_west"}}
+	want := partition{"eu_west", "FOR VALUES IN ('eu_west')"}
+	if got, _ := p.partitionOf(rec); got != want {
+		t.Errorf("partitionOf() = %v, want %v", got, want)
+	}
+
+	rec = &Record{Values: map[string]string{"region": "O'Hare"}}
+	want = partition{partitionSuffix("O'Hare"), "FOR VALUES IN ('O''Hare')"}
+	if got, _ := p.partitionOf(rec); got != want {
+		t.Errorf("partitionOf() = %v, want %v", got, want)
+	}
+
+	rec = &Record{Values: map[string]string{}}
+	rec.SetNull("region")
+	want = partition{"null", "FOR VALUES IN (NULL)"}
+	if got, _ := p.partitionOf(rec); got != want {
+		t.Errorf("partitionOf() of NULL = %v, want %v", got, want)
+	}
+}
+
+func TestPartitionSuffix(t *testing.T) {
+	tests := map[string]string{
+		"eu_west": "eu_west",
+		"EU_West": "eu_west_",
+		"eu-west": "eu_west_",
+		"null":    "null_",
+		"€":       "",
+	}
+	seen := make(map[string]string)
+	for value, prefix := range tests {
+		got := partitionSuffix(value)
+		if !strings.HasPrefix(got, prefix) || (prefix != value && len(got) != len(prefix)+8) {
+			t.Errorf("partitionSuffix(%q) = %q, want %q and a hash", value, got, prefix)
+		}
+		if other, ok := seen[got]; ok {
+			t.Errorf("partitionSuffix(%q) = partitionSuffix(%q) = %q", value, other, got)
+		}
+		seen[got] = value
+	}
+}
+
+func TestSpillRecords(t *testing.T) {
+	in := "id\tday\tnote\n1\t2019-05-31\ta\tb\n2\t2019-06-01\t\n3\t2019-05-01\tc\n"
+	reader, err := NewDelimitedReader(strings.NewReader(strings.Replace(in, "\ta\tb", "\t\"a\tb\"", 1)), '\t')
+	if err != nil {
+		t.Fatalf("NewDelimitedReader() failed: %s", err)
+	}
+	reader.NullRules = &NullRules{Markers: []string{""}}
+
+	p := &partitioning{strategy: "r", column: "day", keyType: "date", interval: PartitionMonth}
+	spills, err := spillRecords(reader, reader.Columns(), p)
+	if err != nil {
+		t.Fatalf("spillRecords() failed: %s", err)
+	}
+
+	got := make(map[string]string)
+	for part, s := range spills {
+		data, err := ioutil.ReadFile(s.file.Name())
+		if err != nil {
+			t.Fatalf("reading spill failed: %s", err)
+		}
+		got[part.suffix] = string(data)
+		os.Remove(s.file.Name())
+		s.file.Close()
+	}
+
+	want := map[string]string{
+		"2019_05": "1\t2019-05-31\ta\\tb\n3\t2019-05-01\tc\n",
+		"2019_06": "2\t2019-06-01\t\\N\n",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("spilled records mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,391 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ProfileOptions describe how a file is profiled.
+type ProfileOptions struct {
+	// Top is the number of most frequent values and patterns reported
+	// per column. Zero means 5.
+	Top int
+
+	// ExactLimit is the number of distinct values of a column counted
+	// exactly. Past it, distinct values are estimated with HyperLogLog
+	// and the most frequent ones tracked approximately. Zero means
+	// 100000.
+	ExactLimit int
+}
+
+// Profile describes the content of a file, column by column.
+type Profile struct {
+	Path       string           `json:"path"`
+	Records    int64            `json:"records"`
+	BadRecords int64            `json:"bad_records"` // records that did not parse or convert
+	Columns    []*ColumnProfile `json:"columns"`
+}
+
+// ColumnProfile describes the values of a column.
+type ColumnProfile struct {
+	Name  string `json:"name"`
+	Nulls int64  `json:"nulls"`
+	Empty int64  `json:"empty"`
+
+	// Distinct counts the distinct values, NULL and empty values aside.
+	// It is estimated unless DistinctExact.
+	Distinct      int64 `json:"distinct"`
+	DistinctExact bool  `json:"distinct_exact"`
+
+	MinLength int `json:"min_length"` // in characters
+	MaxLength int `json:"max_length"`
+
+	// Type is the narrowest type every value that is not NULL or empty
+	// converts to: integer, numeric, boolean, date, timestamp or text.
+	Type string `json:"type"`
+
+	// Min and Max are the smallest and largest values, compared as Type.
+	Min string `json:"min"`
+	Max string `json:"max"`
+
+	// Top are the most frequent values. Unless DistinctExact, they are
+	// approximate and their counts lower bounds.
+	Top      []ValueCount   `json:"top"`
+	Patterns []PatternCount `json:"patterns"`
+}
+
+// ValueCount is a value and how often it occurs.
+type ValueCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// PatternCount is the shape of values, with letters written as A or a and
+// digits as 9, how often it occurs and a value having it.
+type PatternCount struct {
+	Pattern string `json:"pattern"`
+	Count   int64  `json:"count"`
+	Example string `json:"example"`
+}
+
+// Patterns longer than this are cut, and past this many distinct ones,
+// further patterns are lumped together.
+const (
+	maxPatternLength = 40
+	maxPatterns      = 1000
+	otherPattern     = "(other)"
+)
+
+// ProfileSource reads every record of src and profiles its columns.
+// Records that fail to parse or convert are counted and skipped.
+func ProfileSource(src *Source, opts ProfileOptions) (*Profile, error) {
+	if opts.Top <= 0 {
+		opts.Top = 5
+	}
+	if opts.ExactLimit <= 0 {
+		opts.ExactLimit = 100000
+	}
+
+	p := &Profile{Path: src.Path}
+	profilers := make([]*columnProfiler, len(src.Columns()))
+	for i, col := range src.Columns() {
+		profilers[i] = newColumnProfiler(col, opts)
+	}
+
+	for {
+		rec, err := src.Read()
+		if err == io.EOF {
+			break
+		}
+		if _, ok := recordProblem(err); ok {
+			p.BadRecords++
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		p.Records++
+		for _, c := range profilers {
+			if rec.IsNull(c.name) {
+				c.nulls++
+				continue
+			}
+			c.add(rec.Values[c.name])
+		}
+	}
+
+	for _, c := range profilers {
+		p.Columns = append(p.Columns, c.profile(opts.Top))
+	}
+	return p, nil
+}
+
+// columnProfiler gathers the statistics of a column.
+type columnProfiler struct {
+	name  string
+	limit int
+
+	nulls, empty, values int64
+	minLength, maxLength int
+
+	counts map[string]int64 // exact counts, or Misra-Gries counters once sketch is set
+	sketch *hyperLogLog
+
+	patterns map[string]*PatternCount
+
+	// Each candidate type, whether every value so far converts to it, and
+	// its extreme values.
+	candidates []*typeCandidate
+	minText    string
+	maxText    string
+}
+
+type typeCandidate struct {
+	kind      string
+	converter *converter
+	ok        bool
+	min, max  string // the original values
+	lo, hi    string // their converted forms
+}
+
+// detectedTypes are the types a column may be detected as, narrowest
+// first.
+var detectedTypes = []string{"integer", "numeric", "date", "timestamp", "boolean"}
+
+func newColumnProfiler(name string, opts ProfileOptions) *columnProfiler {
+	c := &columnProfiler{name: name, limit: opts.ExactLimit, counts: make(map[string]int64), patterns: make(map[string]*PatternCount)}
+	for _, kind := range detectedTypes {
+		conv, _ := newConverter(Conversion{Type: kind})
+		if kind == "date" {
+			conv.layouts = []string{"2006-01-02"}
+		}
+		c.candidates = append(c.candidates, &typeCandidate{kind: kind, converter: conv, ok: true})
+	}
+	return c
+}
+
+func (c *columnProfiler) add(value string) {
+	if value == "" {
+		c.empty++
+		return
+	}
+	c.values++
+
+	length := utf8.RuneCountInString(value)
+	if c.values == 1 || length < c.minLength {
+		c.minLength = length
+	}
+	if length > c.maxLength {
+		c.maxLength = length
+	}
+	if c.values == 1 || value < c.minText {
+		c.minText = value
+	}
+	if value > c.maxText {
+		c.maxText = value
+	}
+
+	c.count(value)
+	c.addPattern(value)
+
+	for _, t := range c.candidates {
+		if !t.ok {
+			continue
+		}
+		converted, err := t.converter.convert(value)
+		if err != nil {
+			t.ok = false
+			continue
+		}
+		if t.min == "" || less(t.kind, converted, t.lo) {
+			t.min, t.lo = value, converted
+		}
+		if t.max == "" || less(t.kind, t.hi, converted) {
+			t.max, t.hi = value, converted
+		}
+	}
+}
+
+// count counts value exactly until the column has too many distinct
+// values, then approximately: distinct values with a HyperLogLog sketch,
+// frequent ones with the Misra-Gries algorithm. Its counters are lowered
+// in batches, once there are twice frequentCounters of them, so counts are
+// lower bounds.
+func (c *columnProfiler) count(value string) {
+	if c.sketch == nil {
+		c.counts[value]++
+		if len(c.counts) > c.limit {
+			c.sketch = &hyperLogLog{}
+			for v := range c.counts {
+				c.sketch.add(v)
+			}
+			c.counts = topCounts(c.counts, frequentCounters)
+		}
+		return
+	}
+
+	c.sketch.add(value)
+	c.counts[value]++
+	if len(c.counts) > 2*frequentCounters {
+		c.decrement()
+	}
+}
+
+// decrement lowers every counter by the count of the one ranked just below
+// the top frequentCounters, dropping those reaching zero, which leaves at
+// most frequentCounters. Each value is thus decremented at most once per
+// frequentCounters values added, in constant time on average.
+func (c *columnProfiler) decrement() {
+	counts := make([]int64, 0, len(c.counts))
+	for _, n := range c.counts {
+		counts = append(counts, n)
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i] > counts[j] })
+	cut := counts[frequentCounters]
+	for v, n := range c.counts {
+		if n <= cut {
+			delete(c.counts, v)
+		} else {
+			c.counts[v] = n - cut
+		}
+	}
+}
+
+// frequentCounters is the number of values whose counts are kept once a
+// column is counted approximately.
+const frequentCounters = 1000
+
+func (c *columnProfiler) addPattern(value string) {
+	pattern := Pattern(value)
+	if _, ok := c.patterns[pattern]; !ok && len(c.patterns) >= maxPatterns {
+		pattern = otherPattern
+	}
+	pc, ok := c.patterns[pattern]
+	if !ok {
+		pc = &PatternCount{Pattern: pattern, Example: value}
+		c.patterns[pattern] = pc
+	}
+	pc.Count++
+}
+
+func (c *columnProfiler) profile(top int) *ColumnProfile {
+	p := &ColumnProfile{
+		Name: c.name, Nulls: c.nulls, Empty: c.empty,
+		MinLength: c.minLength, MaxLength: c.maxLength,
+		Type: "text", Min: c.minText, Max: c.maxText,
+	}
+
+	if c.sketch == nil {
+		p.Distinct, p.DistinctExact = int64(len(c.counts)), true
+	} else {
+		p.Distinct = c.sketch.estimate()
+	}
+
+	if c.values > 0 {
+		for _, t := range c.candidates {
+			if t.ok {
+				p.Type, p.Min, p.Max = t.kind, t.min, t.max
+				break
+			}
+		}
+	}
+
+	for v, n := range topCounts(c.counts, top) {
+		p.Top = append(p.Top, ValueCount{Value: v, Count: n})
+	}
+	sort.Slice(p.Top, func(i, j int) bool {
+		if p.Top[i].Count != p.Top[j].Count {
+			return p.Top[i].Count > p.Top[j].Count
+		}
+		return p.Top[i].Value < p.Top[j].Value
+	})
+
+	for _, pc := range c.patterns {
+		p.Patterns = append(p.Patterns, *pc)
+	}
+	sort.Slice(p.Patterns, func(i, j int) bool {
+		if p.Patterns[i].Count != p.Patterns[j].Count {
+			return p.Patterns[i].Count > p.Patterns[j].Count
+		}
+		return p.Patterns[i].Pattern < p.Patterns[j].Pattern
+	})
+	if len(p.Patterns) > top {
+		p.Patterns = p.Patterns[:top]
+	}
+	return p
+}
+
+// topCounts returns the n entries of counts with the highest counts, ties
+// broken by value.
+func topCounts(counts map[string]int64, n int) map[string]int64 {
+	if len(counts) <= n {
+		return counts
+	}
+	entries := make([]ValueCount, 0, len(counts))
+	for v, c := range counts {
+		entries = append(entries, ValueCount{Value: v, Count: c})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Value < entries[j].Value
+	})
+	top := make(map[string]int64, n)
+	for _, e := range entries[:n] {
+		top[e.Value] = e.Count
+	}
+	return top
+}
+
+// less compares two values converted to kind.
+func less(kind string, a, b string) bool {
+	switch kind {
+	case "integer":
+		x, _ := strconv.ParseInt(a, 10, 64)
+		y, _ := strconv.ParseInt(b, 10, 64)
+		return x < y
+	case "numeric":
+		x, _ := strconv.ParseFloat(a, 64)
+		y, _ := strconv.ParseFloat(b, 64)
+		return x < y
+	case "boolean":
+		return a == "false" && b == "true"
+	}
+	// Dates and timestamps in their canonical form sort as text.
+	return a < b
+}
+
+// Pattern returns the shape of value: upper and lower case letters become
+// A and a, digits 9, and anything else is kept. Long values are cut.
+func Pattern(value string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range value {
+		if n == maxPatternLength {
+			b.WriteString("…")
+			break
+		}
+		switch {
+		case unicode.IsUpper(r):
+			b.WriteRune('A')
+		case unicode.IsLetter(r):
+			b.WriteRune('a')
+		case unicode.IsDigit(r):
+			b.WriteRune('9')
+		default:
+			b.WriteRune(r)
+		}
+		n++
+	}
+	return b.String()
+}
@@ -0,0 +1,162 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestProfileSource(t *testing.T) {
+	f, err := ioutil.TempFile("", "*.csv")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err)
+	}
+	defer os.Remove(f.Name()) // clean up
+	f.WriteString("id,amount,day,code,flag\n" +
+		"1,10.5,2019-06-01,AB-12,yes\n" +
+		"2,-3,2019-05-31,AB-34,no\n" +
+		"10,\\N,2019-06-02,cd-5,yes\n" +
+		"3,7,,AB-12,\n" +
+		"4,x,y\n")
+	f.Close()
+
+	src, err := OpenSource(f.Name(), SourceOptions{Nulls: &NullRules{Markers: []string{`\N`}}})
+	if err != nil {
+		t.Fatalf("OpenSource() failed: %s", err)
+	}
+	defer src.Close()
+
+	got, err := ProfileSource(src, ProfileOptions{Top: 2})
+	if err != nil {
+		t.Fatalf("ProfileSource() failed: %s", err)
+	}
+
+	want := &Profile{
+		Path: f.Name(), Records: 4, BadRecords: 1,
+		Columns: []*ColumnProfile{
+			{
+				Name: "id", Distinct: 4, DistinctExact: true, MinLength: 1, MaxLength: 2,
+				Type: "integer", Min: "1", Max: "10",
+				Top:      []ValueCount{{"1", 1}, {"10", 1}},
+				Patterns: []PatternCount{{"9", 3, "1"}, {"99", 1, "10"}},
+			},
+			{
+				Name: "amount", Nulls: 1, Distinct: 3, DistinctExact: true, MinLength: 1, MaxLength: 4,
+				Type: "numeric", Min: "-3", Max: "10.5",
+				Top:      []ValueCount{{"-3", 1}, {"10.5", 1}},
+				Patterns: []PatternCount{{"-9", 1, "-3"}, {"9", 1, "7"}},
+			},
+			{
+				Name: "day", Empty: 1, Distinct: 3, DistinctExact: true, MinLength: 10, MaxLength: 10,
+				Type: "date", Min: "2019-05-31", Max: "2019-06-02",
+				Top:      []ValueCount{{"2019-05-31", 1}, {"2019-06-01", 1}},
+				Patterns: []PatternCount{{"9999-99-99", 3, "2019-06-01"}},
+			},
+			{
+				Name: "code", Distinct: 3, DistinctExact: true, MinLength: 4, MaxLength: 5,
+				Type: "text", Min: "AB-12", Max: "cd-5",
+				Top:      []ValueCount{{"AB-12", 2}, {"AB-34", 1}},
+				Patterns: []PatternCount{{"AA-99", 3, "AB-12"}, {"aa-9", 1, "cd-5"}},
+			},
+			{
+				Name: "flag", Empty: 1, Distinct: 2, DistinctExact: true, MinLength: 2, MaxLength: 3,
+				Type: "boolean", Min: "no", Max: "yes",
+				Top:      []ValueCount{{"yes", 2}, {"no", 1}},
+				Patterns: []PatternCount{{"aaa", 2, "yes"}, {"aa", 1, "no"}},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ProfileSource() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestProfileApproximate(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("id,kind\n")
+	for i := 0; i < 20000; i++ {
+		kind := "common"
+		if i%10 == 0 {
+			kind = fmt.Sprintf("rare%d", i)
+		}
+		fmt.Fprintf(&b, "%d,%s\n", i, kind)
+	}
+	f, err := ioutil.TempFile("", "*.csv")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err)
+	}
+	defer os.Remove(f.Name()) // clean up
+	f.WriteString(b.String())
+	f.Close()
+
+	src, err := OpenSource(f.Name(), SourceOptions{})
+	if err != nil {
+		t.Fatalf("OpenSource() failed: %s", err)
+	}
+	defer src.Close()
+
+	got, err := ProfileSource(src, ProfileOptions{Top: 1, ExactLimit: 100})
+	if err != nil {
+		t.Fatalf("ProfileSource() failed: %s", err)
+	}
+
+	for _, c := range got.Columns {
+		if c.DistinctExact {
+			t.Errorf("column %s counted exactly past the limit", c.Name)
+		}
+	}
+	if d := got.Columns[0].Distinct; d < 19400 || d > 20600 {
+		t.Errorf("distinct ids = %d, want about 20000", d)
+	}
+	if d := got.Columns[1].Distinct; d < 1940 || d > 2060 {
+		t.Errorf("distinct kinds = %d, want about 2001", d)
+	}
+	if top := got.Columns[1].Top; len(top) != 1 || top[0].Value != "common" || top[0].Count > 18000 {
+		t.Errorf("top kind = %v, want common with at most 18000", top)
+	}
+}
+
+func TestColumnProfilerCount(t *testing.T) {
+	c := newColumnProfiler("kind", ProfileOptions{ExactLimit: 10})
+	const n = 100000
+	for i := 0; i < n; i++ {
+		if i%3 == 0 {
+			c.count("common")
+		} else {
+			c.count(fmt.Sprintf("rare%d", i))
+		}
+	}
+
+	if len(c.counts) > 2*frequentCounters {
+		t.Errorf("kept %d counters, want at most %d", len(c.counts), 2*frequentCounters)
+	}
+	// Misra-Gries undercounts by at most n / (frequentCounters + 1).
+	want := int64((n + 2) / 3)
+	if got := c.counts["common"]; got > want || got < want-n/(frequentCounters+1) {
+		t.Errorf("count of common = %d, want at most %d and at least %d", got, want, want-n/(frequentCounters+1))
+	}
+}
+
+func TestPattern(t *testing.T) {
+
+	tests := map[string]string{
+		"AB-1234":                   "AA-9999",
+		"Zoë 7":                     "Aaa 9",
+		"":                          "",
+		strings.Repeat("x", 50):     strings.Repeat("a", 40) + "…",
+		"2019-06-01T10:00:00+02:00": "9999-99-99A99:99:99+99:99",
+	}
+
+	for value, want := range tests {
+		if got := Pattern(value); got != want {
+			t.Errorf("Pattern(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// RecordReader is implemented by anything that yields Records one at a time,
+// such as Reader.
+type RecordReader interface {
+	Read() (*Record, error)
+}
+
+type Reader struct {
+	source        recordSource
+	currentLine   uint64
+	currentRecord uint64
+	columns       []string
+	comma         rune
+	controls      *Controls
+
+	// NullRules, if set, decide which values are NULL. Without rules no
+	// value is NULL.
+	NullRules *NullRules
+}
+
+type Record struct {
+	LineNumber   uint64
+	RecordNumber uint64
+	Values       map[string]string
+
+	// Nulls holds the columns whose value is NULL. Their entry in Values
+	// is the empty string. It is nil when the record has no NULLs.
+	Nulls map[string]bool
+}
+
+// IsNull reports whether column is NULL in the record.
+func (rec *Record) IsNull(column string) bool {
+	return rec.Nulls[column]
+}
+
+// Set sets the value of column, clearing any NULL.
+func (rec *Record) Set(column string, value string) {
+	delete(rec.Nulls, column)
+	rec.Values[column] = value
+}
+
+// SetNull marks column as NULL in the record.
+func (rec *Record) SetNull(column string) {
+	if rec.Nulls == nil {
+		rec.Nulls = make(map[string]bool)
+	}
+	rec.Nulls[column] = true
+	rec.Values[column] = ""
+}
+
+func NewReader(csv *csv.Reader) (*Reader, error) {
+	return newReader(&csvSource{reader: csv}, csv.Comma)
+}
+
+// NewDelimitedReader returns a Reader that parses r itself rather than
+// through encoding/csv. Unlike NewReader it knows which fields were quoted,
+// so NullRules can tell "" from an empty field, and line numbers stay
+// correct when quoted fields span several lines.
+func NewDelimitedReader(r io.Reader, comma rune) (*Reader, error) {
+	return newReader(newScanner(r, comma), comma)
+}
+
+func newReader(source recordSource, comma rune) (*Reader, error) {
+
+	r := &Reader{source: source, comma: comma}
+
+	header, err := source.readRecord()
+	if err != nil {
+		return nil, err
+	}
+	r.columns = header.fields
+	r.currentLine = header.line
+
+	return r, nil
+}
+
+// SetControls makes the Reader recognize the control records described by
+// controls and leave them out of its output. If the row read as the column
+// header is itself a header control record, the next row is used instead.
+func (r *Reader) SetControls(controls *Controls) error {
+	r.controls = controls
+	for r.currentRecord == 0 && controls.match(&rawRecord{line: r.currentLine, fields: r.columns}, r.comma, true) {
+		header, err := r.source.readRecord()
+		if err != nil {
+			return err
+		}
+		r.columns = header.fields
+		r.currentLine = header.line
+	}
+	return nil
+}
+
+// Columns returns the column names read from the header.
+func (r *Reader) Columns() []string {
+	return r.columns
+}
+
+func (r *Reader) Read() (*Record, error) {
+
+	raw, err := r.source.readRecord()
+	for err == nil && r.controls != nil && r.controls.match(raw, r.comma, false) {
+		raw, err = r.source.readRecord()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(raw.fields) != len(r.columns) {
+		return nil, &csv.ParseError{StartLine: int(raw.line), Line: int(raw.line), Err: csv.ErrFieldCount}
+	}
+
+	r.currentRecord++
+	r.currentLine = raw.line
+	outRec := &Record{RecordNumber: r.currentRecord, LineNumber: r.currentLine, Values: make(map[string]string)}
+
+	for i, v := range r.columns {
+		outRec.Values[v] = raw.fields[i]
+		if r.NullRules != nil && r.NullRules.IsNull(v, raw.fields[i], raw.quoted != nil && raw.quoted[i]) {
+			outRec.SetNull(v)
+		}
+	}
+
+	return outRec, nil
+}
+
+func (r *Reader) ReadAll() (records []*Record, err error) {
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
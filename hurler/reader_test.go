@@ -2,7 +2,7 @@
 Copyright © 2019 Jason Walker <ragin.jason@me.com>
 This file is part of pghurler.
 */
-package main
+package hurler
 
 import (
 	"encoding/csv"
@@ -40,12 +40,12 @@ func TestNewReader(t *testing.T) {
 		},
 		"header-only reader": {
 			strings.NewReader(headerString),
-			&Reader{nil, 1, 0, []string{"col1", "col2"}},
+			&Reader{currentLine: 1, columns: []string{"col1", "col2"}},
 			nil,
 		},
 		"header and data reader": {
 			strings.NewReader(headerDataString),
-			&Reader{nil, 1, 0, []string{"col1", "col2"}},
+			&Reader{currentLine: 1, columns: []string{"col1", "col2"}},
 			nil,
 		},
 	}
@@ -104,12 +104,12 @@ func TestRead(t *testing.T) {
 		},
 		"one record reader": {
 			strings.NewReader(headerDataString),
-			&Record{2, 1, map[string]string{"col1": "val1", "col2": "val2"}},
+			&Record{LineNumber: 2, RecordNumber: 1, Values: map[string]string{"col1": "val1", "col2": "val2"}},
 			nil,
 		},
 		"two record reader": {
 			strings.NewReader(headerDataString + "\n" + dataString),
-			&Record{2, 1, map[string]string{"col1": "val1", "col2": "val2"}},
+			&Record{LineNumber: 2, RecordNumber: 1, Values: map[string]string{"col1": "val1", "col2": "val2"}},
 			nil,
 		},
 	}
@@ -170,15 +170,15 @@ func TestReadAll(t *testing.T) {
 		"one record reader": {
 			strings.NewReader(headerDataString),
 			[]*Record{
-				{2, 1, map[string]string{"col1": "val1", "col2": "val2"}},
+				{LineNumber: 2, RecordNumber: 1, Values: map[string]string{"col1": "val1", "col2": "val2"}},
 			},
 			nil,
 		},
 		"two record reader": {
 			strings.NewReader(headerDataString + "\n" + dataString),
 			[]*Record{
-				{2, 1, map[string]string{"col1": "val1", "col2": "val2"}},
-				{3, 2, map[string]string{"col1": "val1", "col2": "val2"}},
+				{LineNumber: 2, RecordNumber: 1, Values: map[string]string{"col1": "val1", "col2": "val2"}},
+				{LineNumber: 3, RecordNumber: 2, Values: map[string]string{"col1": "val1", "col2": "val2"}},
 			},
 			nil,
 		},
@@ -0,0 +1,439 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"strings"
+	"unicode/utf8"
+)
+
+// Replace strategies for ModeReplace.
+const (
+	// ReplaceSwap loads a shadow table, builds its indexes, then renames it
+	// over the target. Readers keep using the old table until the swap.
+	// The shadow takes over the target's owner, privileges and sequences;
+	// a partitioned target, a partition, or a target with triggers, row
+	// level security or publications is refused, as they would be lost.
+	ReplaceSwap = "swap"
+
+	// ReplaceTruncate truncates the target and loads it. Readers wait on
+	// the truncation's lock until the load commits.
+	ReplaceTruncate = "truncate"
+)
+
+// tableIndex is an index of the target table to rebuild on its shadow.
+type tableIndex struct {
+	name       string
+	definition string // from pg_get_indexdef
+	constraint string // name of the constraint the index backs, if any
+	condef     string // from pg_get_constraintdef
+}
+
+const tableIndexesSQL = `SELECT c.relname, pg_get_indexdef(i.indexrelid), coalesce(con.conname, ''), coalesce(pg_get_constraintdef(con.oid), '')
+FROM pg_index i
+JOIN pg_class c ON c.oid = i.indexrelid
+LEFT JOIN pg_constraint con ON con.conindid = i.indexrelid AND con.conrelid = i.indrelid AND con.contype IN ('p', 'u', 'x')
+WHERE i.indrelid = $1::regclass
+ORDER BY c.relname`
+
+// tableForeignKeysSQL lists the foreign keys of a table, which LIKE leaves
+// behind.
+const tableForeignKeysSQL = `SELECT conname, pg_get_constraintdef(oid)
+FROM pg_constraint
+WHERE conrelid = $1::regclass AND contype = 'f'
+ORDER BY conname`
+
+// swapCheckSQL tells the schema, name and owner of a table, whether it is
+// partitioned or a partition, and whether it has triggers, row level
+// security, publications or a foreign key to itself, which a shadow table
+// would not inherit.
+const swapCheckSQL = `SELECT n.nspname, c.relname, pg_get_userbyid(c.relowner),
+	c.relkind = 'p', c.relispartition,
+	EXISTS (SELECT 1 FROM pg_trigger WHERE tgrelid = c.oid AND NOT tgisinternal),
+	c.relrowsecurity OR EXISTS (SELECT 1 FROM pg_policy WHERE polrelid = c.oid),
+	EXISTS (SELECT 1 FROM pg_publication_rel WHERE prrelid = c.oid),
+	EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = c.oid AND confrelid = c.oid AND contype = 'f')
+FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.oid = $1::regclass`
+
+// swapTarget is the table a swap replaces, named as Postgres resolved it.
+type swapTarget struct {
+	schema string
+	name   string
+	owner  string
+}
+
+// tableForeignKey is a foreign key of the target table to rebuild on its
+// shadow.
+type tableForeignKey struct {
+	name       string
+	definition string // from pg_get_constraintdef
+}
+
+// tableGrant is a privilege granted on a table, or on one of its columns.
+type tableGrant struct {
+	privilege string
+	column    string // empty for the whole table
+	grantee   string // empty for PUBLIC
+	grantable bool
+}
+
+// tableGrantsSQL lists the privileges granted on a table and its columns to
+// others than its owner, whose own are implicit.
+const tableGrantsSQL = `SELECT a.privilege_type, '', coalesce(r.rolname, ''), a.is_grantable
+FROM pg_class c
+CROSS JOIN aclexplode(c.relacl) a
+LEFT JOIN pg_roles r ON r.oid = a.grantee
+WHERE c.oid = $1::regclass AND a.grantee <> c.relowner
+UNION ALL
+SELECT a.privilege_type, att.attname, coalesce(r.rolname, ''), a.is_grantable
+FROM pg_class c
+JOIN pg_attribute att ON att.attrelid = c.oid AND att.attnum > 0 AND NOT att.attisdropped
+CROSS JOIN aclexplode(att.attacl) a
+LEFT JOIN pg_roles r ON r.oid = a.grantee
+WHERE c.oid = $1::regclass AND a.grantee <> c.relowner
+ORDER BY 2, 3, 1`
+
+// tableSequence is the sequence a serial or identity column of a table
+// takes its values from.
+type tableSequence struct {
+	column   string
+	sequence string // qualified and quoted as needed
+	name     string // the sequence's own name
+	identity bool
+	shadow   string // the sequence of the shadow's identity column
+}
+
+const tableSequencesSQL = `SELECT a.attname, s.seq, c.relname, a.attidentity <> ''
+FROM pg_attribute a
+CROSS JOIN pg_get_serial_sequence(a.attrelid::regclass::text, a.attname) s (seq)
+JOIN pg_class c ON c.oid = s.seq::regclass
+WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped AND s.seq IS NOT NULL
+ORDER BY a.attnum`
+
+// replaceRecords replaces the whole content of the target with the records.
+func replaceRecords(ctx context.Context, tx pgx.Tx, src *Source, opts LoadOptions) (*LoadResult, error) {
+	if opts.Replace == ReplaceTruncate {
+		if _, err := tx.Exec(ctx, "TRUNCATE "+quoteTable(opts.Table)); err != nil {
+			return nil, err
+		}
+		return appendRecords(ctx, tx, src, opts)
+	}
+
+	target, err := checkSwappable(ctx, tx, opts.Table)
+	if err != nil {
+		return nil, err
+	}
+	// The shadow goes in the table's own schema, which for an unqualified
+	// name need not be the first on the search path.
+	table := joinTable(target.schema, target.name)
+	shadowName := shortIdentifier(target.name + "_pghurler_new")
+	shadow := joinTable(target.schema, shadowName)
+	old := shortIdentifier(target.name + "_pghurler_old")
+
+	indexes, err := tableIndexes(ctx, tx, table)
+	if err != nil {
+		return nil, err
+	}
+	grants, err := tableGrants(ctx, tx, table)
+	if err != nil {
+		return nil, err
+	}
+	sequences, err := tableSequences(ctx, tx, table)
+	if err != nil {
+		return nil, err
+	}
+	foreignKeys, err := tableForeignKeys(ctx, tx, table)
+	if err != nil {
+		return nil, err
+	}
+
+	// The shadow takes over the table's owner and privileges, which LIKE
+	// leaves behind.
+	create := []string{
+		"CREATE TABLE " + quoteTable(shadow) + " (LIKE " + quoteTable(table) + " INCLUDING ALL EXCLUDING INDEXES)",
+		"ALTER TABLE " + quoteTable(shadow) + " OWNER TO " + pgx.Identifier{target.owner}.Sanitize(),
+	}
+	for _, g := range grants {
+		create = append(create, grantSQL(g, shadow))
+	}
+	for _, sql := range create {
+		if _, err := tx.Exec(ctx, sql); err != nil {
+			return nil, err
+		}
+	}
+	for i, seq := range sequences {
+		if seq.identity {
+			if err := tx.QueryRow(ctx, "SELECT pg_get_serial_sequence($1, $2)", quoteTable(shadow), seq.column).Scan(&sequences[i].shadow); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	rows, err := copyRecords(ctx, tx.Conn().PgConn(), shadow, src.Columns(), src)
+	if err != nil {
+		return nil, err
+	}
+
+	// Index names are unique within a schema, so the shadow's indexes are
+	// built under temporary names, after the shadow's own so that they
+	// differ from those of another table's load, and renamed once the old
+	// table is gone.
+	var renames []string
+	for i, idx := range indexes {
+		tmp := shortIdentifier(fmt.Sprintf("%s_index_%d", shadowName, i+1))
+		build, rename, err := rebuildIndexSQL(idx, shadow, table, tmp)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(ctx, build); err != nil {
+			return nil, fmt.Errorf("rebuilding index %s: %s", idx.name, err)
+		}
+		renames = append(renames, rename)
+	}
+	// Constraint names are only unique within a table, so foreign keys
+	// keep theirs. Checking them once the rows are in is cheaper than
+	// row by row.
+	for _, fk := range foreignKeys {
+		if _, err := tx.Exec(ctx, foreignKeySQL(fk, shadow)); err != nil {
+			return nil, fmt.Errorf("rebuilding foreign key %s: %s", fk.name, err)
+		}
+	}
+
+	swap := []string{
+		"ALTER TABLE " + quoteTable(table) + " RENAME TO " + pgx.Identifier{old}.Sanitize(),
+		"ALTER TABLE " + quoteTable(shadow) + " RENAME TO " + pgx.Identifier{target.name}.Sanitize(),
+	}
+	for _, seq := range sequences {
+		keep, rename := keepSequenceSQL(seq, table)
+		swap = append(swap, keep)
+		if rename != "" {
+			renames = append(renames, rename)
+		}
+	}
+	swap = append(swap, "DROP TABLE "+quoteTable(joinTable(target.schema, old)))
+	for _, sql := range append(swap, renames...) {
+		if _, err := tx.Exec(ctx, sql); err != nil {
+			return nil, fmt.Errorf("swapping in the new table (the truncate strategy avoids this): %s", err)
+		}
+	}
+
+	return &LoadResult{Rows: rows, Inserted: rows}, nil
+}
+
+// checkSwappable resolves table, failing if it is partitioned, whose
+// partitions would be dropped with it, or a partition, which the shadow
+// would not be, or if it has triggers, row level security, publications or
+// a foreign key to itself, which would not carry over to a shadow.
+func checkSwappable(ctx context.Context, tx pgx.Tx, table string) (*swapTarget, error) {
+	t := &swapTarget{}
+	var partitioned, partition, triggers, security, published, selfReference bool
+	err := tx.QueryRow(ctx, swapCheckSQL, quoteTable(table)).Scan(&t.schema, &t.name, &t.owner,
+		&partitioned, &partition, &triggers, &security, &published, &selfReference)
+	if err != nil {
+		return nil, err
+	}
+	if partitioned {
+		return nil, fmt.Errorf("table %s is partitioned, and swapping in a new table would drop its partitions; use the truncate strategy", table)
+	}
+	if partition {
+		return nil, fmt.Errorf("table %s is a partition, which a new table swapped in would not be; use the truncate strategy", table)
+	}
+	var lost []string
+	if triggers {
+		lost = append(lost, "triggers")
+	}
+	if security {
+		lost = append(lost, "row level security")
+	}
+	if published {
+		lost = append(lost, "publications")
+	}
+	if selfReference {
+		lost = append(lost, "a foreign key to itself")
+	}
+	if len(lost) > 0 {
+		return nil, fmt.Errorf("table %s has %s, which swapping in a new table would lose; use the truncate strategy",
+			table, strings.Join(lost, " and "))
+	}
+	return t, nil
+}
+
+func tableGrants(ctx context.Context, tx pgx.Tx, table string) ([]tableGrant, error) {
+	rows, err := tx.Query(ctx, tableGrantsSQL, quoteTable(table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []tableGrant
+	for rows.Next() {
+		var g tableGrant
+		if err := rows.Scan(&g.privilege, &g.column, &g.grantee, &g.grantable); err != nil {
+			return nil, err
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+// grantSQL gives g on table.
+func grantSQL(g tableGrant, table string) string {
+	privilege := g.privilege
+	if g.column != "" {
+		privilege += " (" + pgx.Identifier{g.column}.Sanitize() + ")"
+	}
+	grantee := "PUBLIC"
+	if g.grantee != "" {
+		grantee = pgx.Identifier{g.grantee}.Sanitize()
+	}
+	sql := "GRANT " + privilege + " ON TABLE " + quoteTable(table) + " TO " + grantee
+	if g.grantable {
+		sql += " WITH GRANT OPTION"
+	}
+	return sql
+}
+
+func tableSequences(ctx context.Context, tx pgx.Tx, table string) ([]tableSequence, error) {
+	rows, err := tx.Query(ctx, tableSequencesSQL, quoteTable(table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sequences []tableSequence
+	for rows.Next() {
+		var seq tableSequence
+		if err := rows.Scan(&seq.column, &seq.sequence, &seq.name, &seq.identity); err != nil {
+			return nil, err
+		}
+		sequences = append(sequences, seq)
+	}
+	return sequences, rows.Err()
+}
+
+// keepSequenceSQL returns the statement, run once the shadow is named table
+// and before the old table is dropped, that keeps seq's values going, and
+// the one, if any, run once it is dropped. A serial column's default still
+// calls the old table's sequence, which passes to the shadow. An identity
+// column of the shadow has a sequence of its own, which goes on from where
+// the old one was and takes its name.
+func keepSequenceSQL(seq tableSequence, table string) (string, string) {
+	if !seq.identity {
+		return "ALTER SEQUENCE " + seq.sequence + " OWNED BY " + quoteTable(table) + "." + pgx.Identifier{seq.column}.Sanitize(), ""
+	}
+	keep := "SELECT setval(" + quoteLiteral(seq.shadow) + ", last_value, is_called) FROM " + seq.sequence
+	rename := "ALTER SEQUENCE " + seq.shadow + " RENAME TO " + pgx.Identifier{seq.name}.Sanitize()
+	return keep, rename
+}
+
+func tableForeignKeys(ctx context.Context, tx pgx.Tx, table string) ([]tableForeignKey, error) {
+	rows, err := tx.Query(ctx, tableForeignKeysSQL, quoteTable(table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var foreignKeys []tableForeignKey
+	for rows.Next() {
+		var fk tableForeignKey
+		if err := rows.Scan(&fk.name, &fk.definition); err != nil {
+			return nil, err
+		}
+		foreignKeys = append(foreignKeys, fk)
+	}
+	return foreignKeys, rows.Err()
+}
+
+// foreignKeySQL adds fk to the shadow table.
+func foreignKeySQL(fk tableForeignKey, shadow string) string {
+	return "ALTER TABLE " + quoteTable(shadow) + " ADD CONSTRAINT " + pgx.Identifier{fk.name}.Sanitize() + " " + fk.definition
+}
+
+func tableIndexes(ctx context.Context, tx pgx.Tx, table string) ([]tableIndex, error) {
+	rows, err := tx.Query(ctx, tableIndexesSQL, quoteTable(table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var indexes []tableIndex
+	for rows.Next() {
+		var idx tableIndex
+		if err := rows.Scan(&idx.name, &idx.definition, &idx.constraint, &idx.condef); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, idx)
+	}
+	return indexes, rows.Err()
+}
+
+// rebuildIndexSQL returns the statement building idx on the shadow table
+// under the name tmp, and the statement giving it back its own name once the
+// shadow has replaced table.
+func rebuildIndexSQL(idx tableIndex, shadow string, table string, tmp string) (string, string, error) {
+	if idx.constraint != "" {
+		build := "ALTER TABLE " + quoteTable(shadow) + " ADD CONSTRAINT " + pgx.Identifier{tmp}.Sanitize() + " " + idx.condef
+		rename := "ALTER TABLE " + quoteTable(table) + " RENAME CONSTRAINT " + pgx.Identifier{tmp}.Sanitize() + " TO " + pgx.Identifier{idx.constraint}.Sanitize()
+		return build, rename, nil
+	}
+
+	// pg_get_indexdef gives "CREATE [UNIQUE] INDEX name ON table USING ...";
+	// everything from USING on describes the index itself.
+	using := strings.Index(idx.definition, " USING ")
+	if using < 0 {
+		return "", "", fmt.Errorf("cannot rebuild index %s from definition %q", idx.name, idx.definition)
+	}
+	create := "CREATE INDEX "
+	if strings.HasPrefix(idx.definition, "CREATE UNIQUE INDEX ") {
+		create = "CREATE UNIQUE INDEX "
+	}
+	build := create + pgx.Identifier{tmp}.Sanitize() + " ON " + quoteTable(shadow) + idx.definition[using:]
+
+	schema, _ := splitTable(table)
+	rename := "ALTER INDEX " + quoteTable(joinTable(schema, tmp)) + " RENAME TO " + pgx.Identifier{idx.name}.Sanitize()
+	return build, rename, nil
+}
+
+// splitTable splits a table name into its schema, which may be empty, and
+// its own name.
+func splitTable(table string) (string, string) {
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		return table[:i], table[i+1:]
+	}
+	return "", table
+}
+
+func joinTable(schema string, name string) string {
+	if schema == "" {
+		return name
+	}
+	return schema + "." + name
+}
+
+// maxIdentifier is the length in bytes past which Postgres truncates names.
+const maxIdentifier = 63
+
+// shortIdentifier returns name if Postgres keeps it whole. A longer name is
+// cut short and ends in a hash of itself instead, so that long names sharing
+// a beginning stay apart.
+func shortIdentifier(name string) string {
+	if len(name) <= maxIdentifier {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	hash := "_" + hex.EncodeToString(sum[:4])
+	end := maxIdentifier - len(hash)
+	for end > 0 && !utf8.RuneStart(name[end]) {
+		end--
+	}
+	return name[:end] + hash
+}
@@ -0,0 +1,224 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"context"
+	"github.com/google/go-cmp/cmp"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRebuildIndexSQL(t *testing.T) {
+
+	tests := map[string]struct {
+		idx    tableIndex
+		table  string
+		build  string
+		rename string
+	}{
+		"primary key": {
+			tableIndex{name: "events_pkey", constraint: "events_pkey", condef: "PRIMARY KEY (id)"},
+			"events",
+			`ALTER TABLE "events_pghurler_new" ADD CONSTRAINT "pghurler_index_1" PRIMARY KEY (id)`,
+			`ALTER TABLE "events" RENAME CONSTRAINT "pghurler_index_1" TO "events_pkey"`,
+		},
+		"plain index": {
+			tableIndex{name: "events_name_idx", definition: "CREATE INDEX events_name_idx ON public.events USING btree (lower(name)) WHERE (name IS NOT NULL)"},
+			"public.events",
+			`CREATE INDEX "pghurler_index_1" ON "public"."events_pghurler_new" USING btree (lower(name)) WHERE (name IS NOT NULL)`,
+			`ALTER INDEX "public"."pghurler_index_1" RENAME TO "events_name_idx"`,
+		},
+		"unique index": {
+			tableIndex{name: "Events Code", definition: `CREATE UNIQUE INDEX "Events Code" ON public.events USING btree (code) INCLUDE (name)`},
+			"events",
+			`CREATE UNIQUE INDEX "pghurler_index_1" ON "events_pghurler_new" USING btree (code) INCLUDE (name)`,
+			`ALTER INDEX "pghurler_index_1" RENAME TO "Events Code"`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			schema, table := splitTable(tc.table)
+			build, rename, err := rebuildIndexSQL(tc.idx, joinTable(schema, table+"_pghurler_new"), tc.table, "pghurler_index_1")
+			if err != nil {
+				t.Fatalf("rebuildIndexSQL() failed: %s", err)
+			}
+
+			if diff := cmp.Diff(tc.build, build); diff != "" {
+				t.Errorf("rebuildIndexSQL() build mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.rename, rename); diff != "" {
+				t.Errorf("rebuildIndexSQL() rename mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRebuildIndexSQLUnknownDefinition(t *testing.T) {
+	_, _, err := rebuildIndexSQL(tableIndex{name: "odd", definition: "CREATE INDEX odd"}, "t_new", "t", "tmp")
+	if err == nil {
+		t.Errorf("rebuildIndexSQL() of a definition without USING succeeded, want error")
+	}
+}
+
+func TestForeignKeySQL(t *testing.T) {
+	fk := tableForeignKey{name: "orders_Customer_fkey", definition: "FOREIGN KEY (customer_id) REFERENCES crm.customers(id) ON DELETE CASCADE"}
+
+	want := `ALTER TABLE "sales"."orders_pghurler_new" ADD CONSTRAINT "orders_Customer_fkey" FOREIGN KEY (customer_id) REFERENCES crm.customers(id) ON DELETE CASCADE`
+	if got := foreignKeySQL(fk, "sales.orders_pghurler_new"); got != want {
+		t.Errorf("foreignKeySQL() = %q, want %q", got, want)
+	}
+}
+
+func TestGrantSQL(t *testing.T) {
+
+	tests := map[string]struct {
+		grant tableGrant
+		want  string
+	}{
+		"table":  {tableGrant{privilege: "SELECT", grantee: "reader"}, `GRANT SELECT ON TABLE "public"."events_pghurler_new" TO "reader"`},
+		"public": {tableGrant{privilege: "SELECT"}, `GRANT SELECT ON TABLE "public"."events_pghurler_new" TO PUBLIC`},
+		"column": {tableGrant{privilege: "UPDATE", column: "Name", grantee: "Clerk", grantable: true},
+			`GRANT UPDATE ("Name") ON TABLE "public"."events_pghurler_new" TO "Clerk" WITH GRANT OPTION`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, grantSQL(tc.grant, "public.events_pghurler_new")); diff != "" {
+				t.Errorf("grantSQL() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestKeepSequenceSQL(t *testing.T) {
+	keep, rename := keepSequenceSQL(tableSequence{column: "id", sequence: "events_id_seq", name: "events_id_seq"}, "public.events")
+	if want := `ALTER SEQUENCE events_id_seq OWNED BY "public"."events"."id"`; keep != want {
+		t.Errorf("keepSequenceSQL() of a serial = %q, want %q", keep, want)
+	}
+	if rename != "" {
+		t.Errorf("keepSequenceSQL() of a serial renames with %q", rename)
+	}
+
+	seq := tableSequence{column: "id", sequence: `public."Events_id_seq"`, name: "Events_id_seq", identity: true, shadow: `public."Events_pghurler_new_id_seq"`}
+	keep, rename = keepSequenceSQL(seq, "public.Events")
+	if want := `SELECT setval('public."Events_pghurler_new_id_seq"', last_value, is_called) FROM public."Events_id_seq"`; keep != want {
+		t.Errorf("keepSequenceSQL() of an identity = %q, want %q", keep, want)
+	}
+	if want := `ALTER SEQUENCE public."Events_pghurler_new_id_seq" RENAME TO "Events_id_seq"`; rename != want {
+		t.Errorf("keepSequenceSQL() of an identity renames with %q, want %q", rename, want)
+	}
+}
+
+func TestShortIdentifier(t *testing.T) {
+	if got := shortIdentifier("events_pghurler_new"); got != "events_pghurler_new" {
+		t.Errorf("shortIdentifier() of a short name = %q", got)
+	}
+
+	long := strings.Repeat("é", 40) + "_pghurler_new"
+	got := shortIdentifier(long)
+	if len(got) > maxIdentifier || !utf8.ValidString(got) {
+		t.Errorf("shortIdentifier() = %q, %d bytes, want at most %d bytes of UTF-8", got, len(got), maxIdentifier)
+	}
+	if other := shortIdentifier(strings.Repeat("é", 40) + "_pghurler_old"); other == got {
+		t.Errorf("shortIdentifier() gave %q for two names", got)
+	}
+}
+
+func TestReplaceSwapSearchPath(t *testing.T) {
+	ctx := context.Background()
+	conn := testConn(t)
+	defer conn.Close(ctx)
+
+	_, err := conn.Exec(ctx, `CREATE SCHEMA pghurler_test_swap;
+		CREATE TABLE pghurler_test_swap.swap_events (id int PRIMARY KEY, name text);
+		INSERT INTO pghurler_test_swap.swap_events VALUES (1, 'old');
+		SET search_path = public, pghurler_test_swap`)
+	if err != nil {
+		t.Fatalf("failed to create table: %s", err)
+	}
+	defer conn.Exec(ctx, "DROP SCHEMA pghurler_test_swap CASCADE") // clean up
+	path := testFile(t, "*.csv", "id,name\n1,ada\n2,grace\n")
+	defer os.Remove(path) // clean up
+
+	src, err := OpenSource(path, SourceOptions{})
+	if err != nil {
+		t.Fatalf("OpenSource() failed: %s", err)
+	}
+	defer src.Close()
+	if _, err := Load(ctx, conn, src, LoadOptions{Table: "swap_events", Mode: ModeReplace, Force: true}); err != nil {
+		t.Fatalf("Load() failed: %s", err)
+	}
+
+	var count int
+	if err := conn.QueryRow(ctx, "SELECT count(*) FROM pghurler_test_swap.swap_events").Scan(&count); err != nil {
+		t.Fatalf("counting rows failed: %s", err)
+	}
+	if count != 2 {
+		t.Errorf("swapped table in its own schema has %d rows, want 2", count)
+	}
+}
+
+func TestReplaceSwapPartitioned(t *testing.T) {
+	ctx := context.Background()
+	conn := testConn(t)
+	defer conn.Close(ctx)
+
+	_, err := conn.Exec(ctx, `CREATE TEMPORARY TABLE swap_parted (id int, name text) PARTITION BY RANGE (id);
+		CREATE TEMPORARY TABLE swap_parted_1 PARTITION OF swap_parted FOR VALUES FROM (0) TO (100)`)
+	if err != nil {
+		t.Fatalf("failed to create table: %s", err)
+	}
+	path := testFile(t, "*.csv", "id,name\n1,ada\n")
+	defer os.Remove(path) // clean up
+
+	for _, table := range []string{"swap_parted", "swap_parted_1"} {
+		src, err := OpenSource(path, SourceOptions{})
+		if err != nil {
+			t.Fatalf("OpenSource() failed: %s", err)
+		}
+		_, err = Load(ctx, conn, src, LoadOptions{Table: table, Mode: ModeReplace, Force: true})
+		src.Close()
+		if err == nil || !strings.Contains(err.Error(), "use the truncate strategy") {
+			t.Errorf("Load() into %s = %v, want it refused", table, err)
+		}
+	}
+}
+
+func TestReplaceSwapForeignKeys(t *testing.T) {
+	ctx := context.Background()
+	conn := testConn(t)
+	defer conn.Close(ctx)
+
+	_, err := conn.Exec(ctx, `CREATE TEMPORARY TABLE swap_regions (code text PRIMARY KEY);
+		INSERT INTO swap_regions VALUES ('eu');
+		CREATE TEMPORARY TABLE swap_sites (id int PRIMARY KEY, region text CONSTRAINT swap_sites_region REFERENCES swap_regions)`)
+	if err != nil {
+		t.Fatalf("failed to create tables: %s", err)
+	}
+	path := testFile(t, "*.csv", "id,region\n1,eu\n")
+	defer os.Remove(path) // clean up
+
+	src, err := OpenSource(path, SourceOptions{})
+	if err != nil {
+		t.Fatalf("OpenSource() failed: %s", err)
+	}
+	defer src.Close()
+	if _, err := Load(ctx, conn, src, LoadOptions{Table: "swap_sites", Mode: ModeReplace, Force: true}); err != nil {
+		t.Fatalf("Load() failed: %s", err)
+	}
+
+	var keys int
+	err = conn.QueryRow(ctx, "SELECT count(*) FROM pg_constraint WHERE conrelid = 'swap_sites'::regclass AND conname = 'swap_sites_region'").Scan(&keys)
+	if err != nil {
+		t.Fatalf("counting foreign keys failed: %s", err)
+	}
+	if keys != 1 {
+		t.Errorf("swapped table has %d of its foreign keys, want 1", keys)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy says how often and how patiently a load failing on a
+// transient error is retried.
+type RetryPolicy struct {
+	// Attempts is the most times a load is tried. Zero or one never
+	// retries it.
+	Attempts int
+
+	// Backoff is the longest wait before the first retry. It doubles
+	// with each retry, up to MaxBackoff. Zero means one second.
+	Backoff time.Duration
+
+	// MaxBackoff caps the wait between attempts. Zero means 30 seconds.
+	MaxBackoff time.Duration
+}
+
+// Error codes of server errors a retry can get past.
+var transientCodes = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"57P01": true, // admin_shutdown
+	"53300": true, // too_many_connections
+}
+
+// IsTransient reports whether err is a failure that may well not happen
+// again: a serialization failure, a deadlock, a server shutting down or out
+// of connections, or a broken or refused network connection.
+func IsTransient(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return transientCodes[pgErr.Code]
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// retryable reports whether a load that failed with err over conn is worth
+// another attempt. A connection the failure closed means it was lost, which
+// is transient however the error reads.
+func retryable(ctx context.Context, err error, conn *pgx.Conn) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	return IsTransient(err) || (conn != nil && conn.IsClosed())
+}
+
+var (
+	jitterMu sync.Mutex
+	jitter   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// delay returns how long to wait before the retry following attempt, the
+// first attempt being 1. The exponential backoff is jittered, so that loads
+// failing together do not all come back at once.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	backoff, max := p.Backoff, p.MaxBackoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	if max <= 0 {
+		max = 30 * time.Second
+	}
+	for i := 1; i < attempt && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return backoff/2 + time.Duration(jitter.Int63n(int64(backoff/2)+1))
+}
+
+// wait sleeps before the retry following attempt, or until ctx is done.
+func (p RetryPolicy) wait(ctx context.Context, attempt int) error {
+	t := time.NewTimer(p.delay(attempt))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsTransient(t *testing.T) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+
+	tests := map[string]struct {
+		err  error
+		want bool
+	}{
+		"serialization failure": {&pgconn.PgError{Code: "40001"}, true},
+		"deadlock":              {&pgconn.PgError{Code: "40P01"}, true},
+		"admin shutdown":        {&pgconn.PgError{Code: "57P01"}, true},
+		"too many connections":  {&pgconn.PgError{Code: "53300"}, true},
+		"wrapped":               {fmt.Errorf("loading: %w", &pgconn.PgError{Code: "40001"}), true},
+		"unique violation":      {&pgconn.PgError{Code: "23505"}, false},
+		"connection reset":      {reset, true},
+		"broken pipe":           {fmt.Errorf("writing: %w", syscall.EPIPE), true},
+		"conversion":            {&ConversionError{Column: "amount", LineNumber: 2, Value: "x", Type: "numeric"}, false},
+		"plain":                 {errors.New("no key columns given"), false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := IsTransient(tc.err); got != tc.want {
+				t.Errorf("IsTransient(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{50, time.Second},
+	}
+
+	for _, tc := range tests {
+		for i := 0; i < 100; i++ {
+			if got := p.delay(tc.attempt); got < tc.max/2 || got > tc.max {
+				t.Fatalf("delay(%d) = %s, want between %s and %s", tc.attempt, got, tc.max/2, tc.max)
+			}
+		}
+	}
+
+	if got := (RetryPolicy{}).delay(1); got < 500*time.Millisecond || got > time.Second {
+		t.Errorf("default delay(1) = %s, want between 500ms and 1s", got)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Route sends the files whose name matches Pattern to Table. Table may hold
+// placeholders such as {table}, replaced by the named capture of the same
+// name, so that ^(?P<table>\w+)_\d{8}\.csv$ and staging.{table} load
+// orders_20190601.csv into staging.orders.
+type Route struct {
+	Pattern string
+	Table   string
+}
+
+// Router picks the route of a file, trying routes in order.
+type Router struct {
+	routes   []Route
+	patterns []*regexp.Regexp
+}
+
+var placeholder = regexp.MustCompile(`\{(\w+)\}`)
+
+func NewRouter(routes []Route) (*Router, error) {
+	r := &Router{routes: routes}
+	for i, route := range routes {
+		re, err := regexp.Compile(route.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %s", i+1, err)
+		}
+		if route.Table == "" {
+			return nil, fmt.Errorf("route %d: no table given", i+1)
+		}
+		for _, m := range placeholder.FindAllStringSubmatch(route.Table, -1) {
+			if subexpIndex(re, m[1]) < 0 {
+				return nil, fmt.Errorf("route %d: table %s uses {%s}, which the pattern does not capture", i+1, route.Table, m[1])
+			}
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// Match finds the first route whose pattern matches the name of the file at
+// path, and returns its index and its table with placeholders filled in. The
+// index is -1 if no route matches.
+func (r *Router) Match(path string) (int, string) {
+	name := filepath.Base(path)
+	for i, re := range r.patterns {
+		m := re.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		table := placeholder.ReplaceAllStringFunc(r.routes[i].Table, func(p string) string {
+			return m[subexpIndex(re, strings.Trim(p, "{}"))]
+		})
+		return i, table
+	}
+	return -1, ""
+}
+
+// subexpIndex returns the index of the capture called name, or -1.
+func subexpIndex(re *regexp.Regexp, name string) int {
+	for i, n := range re.SubexpNames() {
+		if n == name && i > 0 {
+			return i
+		}
+	}
+	return -1
+}
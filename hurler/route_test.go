@@ -0,0 +1,62 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	router, err := NewRouter([]Route{
+		{Pattern: `^(?P<table>\w+)_\d{8}\.csv(\.gz)?$`, Table: "staging.{table}"},
+		{Pattern: `^(?P<vendor>[a-z]+)-(?P<feed>[a-z]+)\.pipe$`, Table: "{vendor}.{feed}_raw"},
+		{Pattern: `\.tsv$`, Table: "staging.misc"},
+	})
+	if err != nil {
+		t.Fatalf("NewRouter() failed: %s", err)
+	}
+
+	tests := map[string]struct {
+		path  string
+		index int
+		table string
+	}{
+		"capture":         {"/drop/orders_20190601.csv", 0, "staging.orders"},
+		"compressed":      {"drop/orders_20190601.csv.gz", 0, "staging.orders"},
+		"two captures":    {"acme-prices.pipe", 1, "acme.prices_raw"},
+		"no placeholders": {"notes.tsv", 2, "staging.misc"},
+		"directory names": {"/x_20190601.csv/readme.txt", -1, ""},
+		"no match":        {"orders.csv", -1, ""},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			index, table := router.Match(tc.path)
+			if index != tc.index || table != tc.table {
+				t.Errorf("Match(%q) = %d, %q, want %d, %q", tc.path, index, table, tc.index, tc.table)
+			}
+		})
+	}
+}
+
+func TestNewRouterErrors(t *testing.T) {
+	tests := map[string]struct {
+		route Route
+		want  string
+	}{
+		"bad pattern":   {Route{Pattern: `(`, Table: "t"}, "route 1: error parsing regexp: missing closing ): `(`"},
+		"no table":      {Route{Pattern: `x`}, "route 1: no table given"},
+		"unknown group": {Route{Pattern: `(?P<name>\w+)`, Table: "{table}"}, "route 1: table {table} uses {table}, which the pattern does not capture"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewRouter([]Route{tc.route})
+			if err == nil || err.Error() != tc.want {
+				t.Errorf("NewRouter() = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"strings"
+)
+
+// rawRecord is a single record as read from the input, before its fields are
+// mapped onto column names.
+type rawRecord struct {
+	line   uint64   // line the record starts on
+	fields []string // field values with quoting removed
+	quoted []bool   // whether each field was quoted; nil if unknown
+}
+
+// recordSource yields raw records from some underlying input.
+type recordSource interface {
+	readRecord() (*rawRecord, error)
+}
+
+// csvSource adapts a csv.Reader to a recordSource. The csv package does not
+// report quoting or physical line numbers, so every field is treated as
+// unquoted and each record is assumed to occupy a single line.
+type csvSource struct {
+	reader *csv.Reader
+	line   uint64
+}
+
+func (s *csvSource) readRecord() (*rawRecord, error) {
+	fields, err := s.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	s.line++
+	return &rawRecord{line: s.line, fields: fields}, nil
+}
+
+// scanner reads delimited records in the same dialect as encoding/csv, but
+// keeps track of which fields were quoted and of the physical line each
+// record starts on, so quoted newlines do not throw line numbers off.
+type scanner struct {
+	reader *bufio.Reader
+	comma  rune
+	line   uint64 // physical lines started so far
+}
+
+func newScanner(r io.Reader, comma rune) *scanner {
+	return &scanner{reader: bufio.NewReader(r), comma: comma}
+}
+
+func (s *scanner) readRecord() (*rawRecord, error) {
+	var (
+		rec        *rawRecord
+		field      strings.Builder
+		quoted     bool // current field started with a quote
+		inQuotes   bool // inside a quoted section of the current field
+		afterQuote bool // current field's closing quote has been read
+	)
+
+	endField := func() {
+		rec.fields = append(rec.fields, field.String())
+		rec.quoted = append(rec.quoted, quoted)
+		field.Reset()
+		quoted, afterQuote = false, false
+	}
+
+	for {
+		c, _, err := s.reader.ReadRune()
+		if err == io.EOF {
+			if rec == nil {
+				return nil, io.EOF
+			}
+			if inQuotes {
+				return nil, &csv.ParseError{StartLine: int(rec.line), Line: int(s.line), Err: csv.ErrQuote}
+			}
+			endField()
+			return rec, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if rec == nil {
+			s.line++
+			rec = &rawRecord{line: s.line}
+		}
+
+		if inQuotes {
+			switch c {
+			case '"':
+				next, _, err := s.reader.ReadRune()
+				if err == nil && next == '"' {
+					field.WriteRune('"')
+					continue
+				}
+				if err == nil {
+					s.reader.UnreadRune()
+				}
+				inQuotes, afterQuote = false, true
+			case '\n':
+				s.line++
+				field.WriteRune(c)
+			default:
+				field.WriteRune(c)
+			}
+			continue
+		}
+
+		if c == '\r' {
+			next, _, err := s.reader.ReadRune()
+			if err == nil && next == '\n' {
+				c = '\n'
+			} else if err == nil {
+				s.reader.UnreadRune()
+			}
+		}
+
+		switch {
+		case c == s.comma:
+			endField()
+		case c == '\n':
+			if len(rec.fields) == 0 && field.Len() == 0 && !quoted {
+				// Blank lines are skipped, as encoding/csv does.
+				rec = nil
+				continue
+			}
+			endField()
+			return rec, nil
+		case afterQuote:
+			// Skip the rest of the row, so that reading can go on with
+			// the next.
+			for c != '\n' && err == nil {
+				c, _, err = s.reader.ReadRune()
+			}
+			return nil, &csv.ParseError{StartLine: int(rec.line), Line: int(s.line), Err: csv.ErrQuote}
+		case c == '"' && field.Len() == 0 && !quoted:
+			quoted, inQuotes = true, true
+		default:
+			field.WriteRune(c)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"encoding/csv"
+	"github.com/google/go-cmp/cmp"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestScannerReadRecord(t *testing.T) {
+
+	tests := map[string]struct {
+		input string
+		comma rune
+		want  []*rawRecord
+		err   error
+	}{
+		"empty input": {
+			"",
+			',',
+			nil,
+			nil,
+		},
+		"unquoted fields": {
+			"a,b\nc,d\n",
+			',',
+			[]*rawRecord{
+				{line: 1, fields: []string{"a", "b"}, quoted: []bool{false, false}},
+				{line: 2, fields: []string{"c", "d"}, quoted: []bool{false, false}},
+			},
+			nil,
+		},
+		"no trailing newline": {
+			"a,b",
+			',',
+			[]*rawRecord{
+				{line: 1, fields: []string{"a", "b"}, quoted: []bool{false, false}},
+			},
+			nil,
+		},
+		"quoted and unquoted empty": {
+			`"",` + "\n",
+			',',
+			[]*rawRecord{
+				{line: 1, fields: []string{"", ""}, quoted: []bool{true, false}},
+			},
+			nil,
+		},
+		"escaped quote": {
+			`"say ""hi""",x`,
+			',',
+			[]*rawRecord{
+				{line: 1, fields: []string{`say "hi"`, "x"}, quoted: []bool{true, false}},
+			},
+			nil,
+		},
+		"quoted newline": {
+			"\"a\nb\",c\nd,e\n",
+			',',
+			[]*rawRecord{
+				{line: 1, fields: []string{"a\nb", "c"}, quoted: []bool{true, false}},
+				{line: 3, fields: []string{"d", "e"}, quoted: []bool{false, false}},
+			},
+			nil,
+		},
+		"blank lines and crlf": {
+			"a|b\r\n\r\n\nc|d\r\n",
+			'|',
+			[]*rawRecord{
+				{line: 1, fields: []string{"a", "b"}, quoted: []bool{false, false}},
+				{line: 4, fields: []string{"c", "d"}, quoted: []bool{false, false}},
+			},
+			nil,
+		},
+		"unterminated quote": {
+			"\"a,b\n",
+			',',
+			nil,
+			&csv.ParseError{StartLine: 1, Line: 2, Err: csv.ErrQuote},
+		},
+		"text after closing quote": {
+			`"a"b,c`,
+			',',
+			nil,
+			&csv.ParseError{StartLine: 1, Line: 1, Err: csv.ErrQuote},
+		},
+	}
+
+	equateErrorMessage := cmp.Comparer(func(x, y error) bool {
+		if x == nil || y == nil {
+			return x == nil && y == nil
+		}
+		return x.Error() == y.Error()
+	})
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := newScanner(strings.NewReader(tc.input), tc.comma)
+
+			var got []*rawRecord
+			var err error
+			for {
+				var rec *rawRecord
+				rec, err = s.readRecord()
+				if err == io.EOF {
+					err = nil
+					break
+				}
+				if err != nil {
+					got = nil
+					break
+				}
+				got = append(got, rec)
+			}
+
+			if diff := cmp.Diff(tc.err, err, equateErrorMessage); diff != "" {
+				t.Fatalf("Error mismatch for readRecord() (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(rawRecord{})); diff != "" {
+				t.Errorf("readRecord() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestScannerAfterBadQuote(t *testing.T) {
+	s := newScanner(strings.NewReader("\"a\"b,c\nd,e\n"), ',')
+
+	if _, err := s.readRecord(); err == nil {
+		t.Fatalf("readRecord() of text after a closing quote succeeded")
+	}
+	rec, err := s.readRecord()
+	if err != nil {
+		t.Fatalf("readRecord() after a bad quote failed: %s", err)
+	}
+	want := &rawRecord{line: 2, fields: []string{"d", "e"}, quoted: []bool{false, false}}
+	if diff := cmp.Diff(want, rec, cmp.AllowUnexported(rawRecord{})); diff != "" {
+		t.Errorf("readRecord() after a bad quote mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"strings"
+)
+
+// Columns ModeSCD2 keeps the history of each row in.
+const (
+	ValidFrom = "valid_from"
+	ValidTo   = "valid_to" // NULL while the version is current
+	IsCurrent = "is_current"
+)
+
+// scd2Records copies the records into a staging table, closes the current
+// version of every row whose values changed, then inserts a new current
+// version for those rows and for new keys.
+func scd2Records(ctx context.Context, tx pgx.Tx, src *Source, opts LoadOptions) (*LoadResult, error) {
+	var columns []string
+	for _, col := range src.Columns() {
+		if col != opts.Effective {
+			columns = append(columns, col)
+		}
+	}
+	if err := checkEffective(ctx, tx, opts); err != nil {
+		return nil, err
+	}
+
+	// The effective date is staged with the type of valid_from, whatever
+	// the file calls it.
+	list := quoteColumns(columns)
+	if opts.Effective != "" {
+		list += ", " + pgx.Identifier{ValidFrom}.Sanitize() + " AS " + pgx.Identifier{opts.Effective}.Sanitize()
+	}
+	rows, err := stageSelected(ctx, tx, opts.Table, list, src.Columns(), src)
+	if err != nil {
+		return nil, err
+	}
+	// Versions of a key the file repeats would all be inserted as current.
+	if err := checkUnique(ctx, tx, stageTable, opts.Key); err != nil {
+		return nil, err
+	}
+
+	res := &LoadResult{Rows: rows}
+	if sql := scd2CloseSQL(opts.Table, stageTable, columns, opts); sql != "" {
+		tag, err := tx.Exec(ctx, sql)
+		if err != nil {
+			return nil, err
+		}
+		res.Updated = tag.RowsAffected()
+	}
+
+	tag, err := tx.Exec(ctx, scd2InsertSQL(opts.Table, stageTable, columns, opts))
+	if err != nil {
+		return nil, err
+	}
+	res.Inserted = tag.RowsAffected() - res.Updated
+	if err := dropStage(ctx, tx); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// checkEffective fails if the effective date column of the file is also a
+// column of the table other than valid_from, as it is not loaded but only
+// read for valid_from.
+func checkEffective(ctx context.Context, tx pgx.Tx, opts LoadOptions) error {
+	if opts.Effective == "" || opts.Effective == ValidFrom {
+		return nil
+	}
+	columns, err := TableColumns(ctx, tx.Conn(), opts.Table)
+	if err != nil {
+		return err
+	}
+	for _, c := range columns {
+		if c.Name == opts.Effective {
+			return fmt.Errorf("effective date column '%s' is also a column of %s, which it would not be loaded into", opts.Effective, opts.Table)
+		}
+	}
+	return nil
+}
+
+// effectiveDate is the expression giving a staged row's valid_from: its
+// effective column, or the load's transaction time.
+func effectiveDate(opts LoadOptions) string {
+	if opts.Effective == "" {
+		return "now()"
+	}
+	return "s." + pgx.Identifier{opts.Effective}.Sanitize()
+}
+
+// scd2CloseSQL ends the current version of the target rows whose non-key
+// values, lineage aside, differ from the staged ones. It is empty when
+// every column is part of the key, as such rows cannot change.
+func scd2CloseSQL(table string, stage string, columns []string, opts LoadOptions) string {
+	var match, old, staged []string
+	for _, col := range columns {
+		q := pgx.Identifier{col}.Sanitize()
+		if contains(opts.Key, col) {
+			match = append(match, "t."+q+" = s."+q)
+			continue
+		}
+		if contains(LineageColumns, col) {
+			continue
+		}
+		old = append(old, "t."+q)
+		staged = append(staged, "s."+q)
+	}
+	if len(old) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("UPDATE " + quoteTable(table) + " t")
+	b.WriteString(" SET " + pgx.Identifier{ValidTo}.Sanitize() + " = " + effectiveDate(opts) + ", " + pgx.Identifier{IsCurrent}.Sanitize() + " = false")
+	b.WriteString(" FROM " + quoteTable(stage) + " s")
+	b.WriteString(" WHERE t." + pgx.Identifier{IsCurrent}.Sanitize() + " AND " + strings.Join(match, " AND "))
+	b.WriteString(" AND (" + strings.Join(old, ", ") + ") IS DISTINCT FROM (" + strings.Join(staged, ", ") + ")")
+	return b.String()
+}
+
+// scd2InsertSQL inserts a current version of every staged row whose key has
+// none, which after scd2CloseSQL are the new and the changed ones.
+func scd2InsertSQL(table string, stage string, columns []string, opts LoadOptions) string {
+	var match, staged []string
+	for _, col := range columns {
+		q := pgx.Identifier{col}.Sanitize()
+		staged = append(staged, "s."+q)
+		if contains(opts.Key, col) {
+			match = append(match, "t."+q+" = s."+q)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("INSERT INTO " + quoteTable(table) + " (" + quoteColumns(columns) + ", " + quoteColumns([]string{ValidFrom, ValidTo, IsCurrent}) + ")")
+	b.WriteString(" SELECT " + strings.Join(staged, ", ") + ", " + effectiveDate(opts) + ", NULL, true")
+	b.WriteString(" FROM " + quoteTable(stage) + " s")
+	b.WriteString(" WHERE NOT EXISTS (SELECT 1 FROM " + quoteTable(table) + " t WHERE t." + pgx.Identifier{IsCurrent}.Sanitize() + " AND " + strings.Join(match, " AND ") + ")")
+	return b.String()
+}
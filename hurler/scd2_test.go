@@ -0,0 +1,101 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"context"
+	"github.com/google/go-cmp/cmp"
+	"os"
+	"testing"
+)
+
+func TestSCD2SQL(t *testing.T) {
+
+	tests := map[string]struct {
+		columns []string
+		opts    LoadOptions
+		close   string
+		insert  string
+	}{
+		"load time": {
+			[]string{"id", "name", "tier"},
+			LoadOptions{Key: []string{"id"}},
+			`UPDATE "customers" t SET "valid_to" = now(), "is_current" = false FROM "pghurler_stage" s` +
+				` WHERE t."is_current" AND t."id" = s."id" AND (t."name", t."tier") IS DISTINCT FROM (s."name", s."tier")`,
+			`INSERT INTO "customers" ("id", "name", "tier", "valid_from", "valid_to", "is_current")` +
+				` SELECT s."id", s."name", s."tier", now(), NULL, true FROM "pghurler_stage" s` +
+				` WHERE NOT EXISTS (SELECT 1 FROM "customers" t WHERE t."is_current" AND t."id" = s."id")`,
+		},
+		"effective column": {
+			[]string{"region", "id", "tier"},
+			LoadOptions{Key: []string{"region", "id"}, Effective: "as_of"},
+			`UPDATE "customers" t SET "valid_to" = s."as_of", "is_current" = false FROM "pghurler_stage" s` +
+				` WHERE t."is_current" AND t."region" = s."region" AND t."id" = s."id" AND (t."tier") IS DISTINCT FROM (s."tier")`,
+			`INSERT INTO "customers" ("region", "id", "tier", "valid_from", "valid_to", "is_current")` +
+				` SELECT s."region", s."id", s."tier", s."as_of", NULL, true FROM "pghurler_stage" s` +
+				` WHERE NOT EXISTS (SELECT 1 FROM "customers" t WHERE t."is_current" AND t."region" = s."region" AND t."id" = s."id")`,
+		},
+		"key only": {
+			[]string{"id"},
+			LoadOptions{Key: []string{"id"}},
+			"",
+			`INSERT INTO "customers" ("id", "valid_from", "valid_to", "is_current")` +
+				` SELECT s."id", now(), NULL, true FROM "pghurler_stage" s` +
+				` WHERE NOT EXISTS (SELECT 1 FROM "customers" t WHERE t."is_current" AND t."id" = s."id")`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.close, scd2CloseSQL("customers", stageTable, tc.columns, tc.opts)); diff != "" {
+				t.Errorf("scd2CloseSQL() mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.insert, scd2InsertSQL("customers", stageTable, tc.columns, tc.opts)); diff != "" {
+				t.Errorf("scd2InsertSQL() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSCD2Refused(t *testing.T) {
+	ctx := context.Background()
+	conn := testConn(t)
+	defer conn.Close(ctx)
+
+	_, err := conn.Exec(ctx, `CREATE TEMPORARY TABLE scd2_events (id int, name text, updated date,
+		valid_from timestamptz, valid_to timestamptz, is_current boolean)`)
+	if err != nil {
+		t.Fatalf("failed to create table: %s", err)
+	}
+
+	tests := map[string]struct {
+		content   string
+		effective string
+		want      string
+	}{
+		"duplicate key": {"id,name,as_of\n1,ada,2019-06-01\n2,grace,2019-06-01\n1,alan,2019-06-02\n", "as_of",
+			"key (1) is in the file 2 times, on lines 2, 4"},
+		"effective in table": {"id,name,updated\n1,ada,2019-06-01\n", "updated",
+			"effective date column 'updated' is also a column of scd2_events, which it would not be loaded into"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := testFile(t, "*.csv", tc.content)
+			defer os.Remove(path) // clean up
+
+			src, err := OpenSource(path, SourceOptions{})
+			if err != nil {
+				t.Fatalf("OpenSource() failed: %s", err)
+			}
+			defer src.Close()
+
+			opts := LoadOptions{Table: "scd2_events", Mode: ModeSCD2, Key: []string{"id"}, Effective: tc.effective}
+			if _, err := Load(ctx, conn, src, opts); err == nil || err.Error() != tc.want {
+				t.Errorf("Load() error = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
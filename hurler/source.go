@@ -0,0 +1,205 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// SourceOptions describe how a delimited file is read and prepared for
+// loading.
+type SourceOptions struct {
+	// Delimiter separates fields. Zero derives it from the file extension.
+	Delimiter rune
+
+	Nulls       *NullRules
+	Controls    []ControlSpec
+	Transforms  []TransformSpec
+	Where       []string
+	Skip        []string
+	Conversions map[string]Conversion
+
+	// LoadTime is when the load started, for derived columns.
+	LoadTime time.Time
+}
+
+// Source is a delimited file opened for loading. Records pass through the
+// pipeline in this order: NULL rules and control record detection, then
+// transforms, the control tally, filters, and finally type conversions.
+// Control totals cover every record of the file, so they are tallied before
+// any is filtered out.
+type Source struct {
+	Path string
+
+	file     *os.File
+	gzip     *gzip.Reader // set when the file is compressed
+	records  RecordReader
+	columns  []string
+	loadTime time.Time
+
+	// Stages of the pipeline, kept to build one per chunk of a parallel
+	// load. All of them may be shared between goroutines.
+	delimiter   rune
+	nulls       *NullRules
+	header      *Reader // the reader of the file's head
+	transformer *Transformer
+	filter      *Filter
+	converter   *Converter
+	controls    *Controls
+	lineage     *LineageReader // lineage to add, without a source
+}
+
+// OpenSource opens the file at path and sets up its pipeline. A file whose
+// name ends in .gz is decompressed as it is read.
+func OpenSource(path string, opts SourceOptions) (*Source, error) {
+	delimiter := opts.Delimiter
+	if delimiter == 0 {
+		var err error
+		if delimiter, err = DeriveDelimiter(path); err != nil {
+			return nil, err
+		}
+	}
+
+	transformer, err := NewTransformer(opts.Transforms, TransformContext{SourceFile: path, LoadTime: opts.LoadTime})
+	if err != nil {
+		return nil, err
+	}
+	filter, err := NewFilter(opts.Where, opts.Skip)
+	if err != nil {
+		return nil, err
+	}
+	converter, err := NewConverter(opts.Conversions)
+	if err != nil {
+		return nil, err
+	}
+	controls, err := NewControls(opts.Controls)
+	if err != nil {
+		return nil, err
+	}
+	controls.converter = converter
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Source{
+		Path: path, file: f, loadTime: opts.LoadTime,
+		delimiter: delimiter, nulls: opts.Nulls,
+		transformer: transformer, filter: filter, converter: converter, controls: controls,
+	}
+
+	var input io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		if s.gzip, err = gzip.NewReader(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		input = s.gzip
+	}
+
+	reader, err := NewDelimitedReader(input, delimiter)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	reader.NullRules = opts.Nulls
+	if err := reader.SetControls(controls); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if s.columns, err = transformer.Columns(reader.Columns()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := filter.CheckColumns(s.columns); err != nil {
+		f.Close()
+		return nil, err
+	}
+	for col := range opts.Conversions {
+		if !contains(s.columns, col) {
+			f.Close()
+			return nil, fmt.Errorf("conversion for unknown column '%s'", col)
+		}
+	}
+
+	s.header = reader
+	s.records = s.pipeline(reader)
+	return s, nil
+}
+
+// pipeline passes the records of reader through every later stage.
+func (s *Source) pipeline(reader *Reader) RecordReader {
+	records := RecordReader(NewConvertingReader(NewFilteringReader(NewTallyingReader(NewTransformingReader(reader, s.transformer), s.controls), s.filter), s.converter))
+	if s.lineage != nil {
+		lineage := *s.lineage
+		lineage.source = records
+		records = &lineage
+	}
+	return records
+}
+
+// Columns returns the columns of the records the source yields.
+func (s *Source) Columns() []string {
+	return s.columns
+}
+
+// addLineage appends lineage columns to the source's records, after every
+// other stage of the pipeline.
+func (s *Source) addLineage(columns []string, loadID int64) error {
+	for _, col := range columns {
+		if contains(s.columns, col) {
+			return fmt.Errorf("lineage column '%s' is already loaded from the file", col)
+		}
+	}
+	loadedAt := s.loadTime
+	if loadedAt.IsZero() {
+		loadedAt = time.Now()
+	}
+	lineage, err := NewLineageReader(nil, columns, Lineage{SourceFile: s.Path, LoadID: loadID, LoadedAt: loadedAt})
+	if err != nil {
+		return err
+	}
+	s.lineage = lineage
+	s.records = s.pipeline(s.header)
+	s.columns = append(append([]string(nil), s.columns...), columns...)
+	return nil
+}
+
+func (s *Source) Read() (*Record, error) {
+	return s.records.Read()
+}
+
+// FilterStats reports how many records each filter rule skipped.
+func (s *Source) FilterStats() []FilterStat {
+	return s.filter.Stats()
+}
+
+// Validate checks the control records found against the records read. It is
+// only meaningful once the source has been read to the end.
+func (s *Source) Validate() error {
+	return s.controls.Validate()
+}
+
+func (s *Source) Close() error {
+	if s.gzip != nil {
+		s.gzip.Close()
+	}
+	return s.file.Close()
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
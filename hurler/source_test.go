@@ -0,0 +1,113 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"compress/gzip"
+	"github.com/google/go-cmp/cmp"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestOpenSource(t *testing.T) {
+	f, err := ioutil.TempFile("", "*.pipe")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err)
+	}
+	defer os.Remove(f.Name()) // clean up
+	content := `id|name|amount|region
+1| ada |1,000.50|EU
+2|grace|N/A|US
+3|test|5|EU
+TRL|3|1005.50
+`
+	if _, err := f.Write([]byte(content)); err != nil {
+		t.Fatalf("failed to write temp file: %s", err)
+	}
+	f.Close()
+
+	opts := SourceOptions{
+		Nulls:       &NullRules{Markers: []string{"N/A"}},
+		Controls:    []ControlSpec{{Kind: "trailer", Pattern: `^TRL\|`, Count: 2, Sums: []ControlSum{{Field: 3, Column: "amount"}}}},
+		Transforms:  []TransformSpec{{Op: OpTrim, Column: "name"}, {Op: OpUpper, Column: "name"}, {Op: OpConstant, Into: "batch", Value: "7"}},
+		Skip:        []string{"name = 'TEST'"},
+		Conversions: map[string]Conversion{"amount": {Type: "numeric"}, "batch": {Type: "integer"}},
+	}
+
+	src, err := OpenSource(f.Name(), opts)
+	if err != nil {
+		t.Fatalf("OpenSource() failed: %s", err)
+	}
+	defer src.Close()
+
+	if diff := cmp.Diff([]string{"id", "name", "amount", "region", "batch"}, src.Columns()); diff != "" {
+		t.Errorf("Columns() mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err := ioutil.ReadAll(NewCopyReader(src, src.Columns()))
+	if err != nil {
+		t.Fatalf("reading source failed: %s", err)
+	}
+	want := "1\tADA\t1000.50\tEU\t7\n2\tGRACE\t\\N\tUS\t7\n"
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("source records mismatch (-want +got):\n%s", diff)
+	}
+
+	// The skipped record still counts towards the trailer's totals.
+	if err := src.Validate(); err != nil {
+		t.Errorf("Validate() failed: %s", err)
+	}
+
+	wantStats := []FilterStat{{Rule: "skip name = 'TEST'", Skipped: 1}}
+	if diff := cmp.Diff(wantStats, src.FilterStats()); diff != "" {
+		t.Errorf("FilterStats() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestOpenSourceUnknownConversion(t *testing.T) {
+	f, err := ioutil.TempFile("", "*.csv")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err)
+	}
+	defer os.Remove(f.Name()) // clean up
+	f.Write([]byte(headerDataString))
+	f.Close()
+
+	_, err = OpenSource(f.Name(), SourceOptions{Conversions: map[string]Conversion{"col3": {Type: "date"}}})
+	if err == nil || err.Error() != "conversion for unknown column 'col3'" {
+		t.Errorf("OpenSource() error = %v, want unknown column error", err)
+	}
+}
+
+func TestOpenSourceGzip(t *testing.T) {
+	f, err := ioutil.TempFile("", "*.tsv.gz")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err)
+	}
+	defer os.Remove(f.Name()) // clean up
+	w := gzip.NewWriter(f)
+	w.Write([]byte("id\tname\n1\tada\n"))
+	w.Close()
+	f.Close()
+
+	src, err := OpenSource(f.Name(), SourceOptions{})
+	if err != nil {
+		t.Fatalf("OpenSource() failed: %s", err)
+	}
+	defer src.Close()
+
+	got, err := ioutil.ReadAll(NewCopyReader(src, src.Columns()))
+	if err != nil {
+		t.Fatalf("reading source failed: %s", err)
+	}
+	if diff := cmp.Diff("1\tada\n", string(got)); diff != "" {
+		t.Errorf("source records mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := src.Split(2); err == nil {
+		t.Errorf("Split() of a compressed file succeeded")
+	}
+}
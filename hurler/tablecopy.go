@@ -0,0 +1,173 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ColumnMap pairs a column of the source table with the column of the
+// target it is copied into.
+type ColumnMap struct {
+	Source string
+	Target string
+}
+
+// ParseColumnMap reads columns given as "name", copied into the column of the
+// same name, or "source:target".
+func ParseColumnMap(specs []string) ([]ColumnMap, error) {
+	columns := make([]ColumnMap, len(specs))
+	for i, spec := range specs {
+		parts := strings.Split(spec, ":")
+		switch {
+		case len(parts) == 1 && parts[0] != "":
+			columns[i] = ColumnMap{Source: parts[0], Target: parts[0]}
+		case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+			columns[i] = ColumnMap{Source: parts[0], Target: parts[1]}
+		default:
+			return nil, fmt.Errorf("invalid column '%s': want name or source:target", spec)
+		}
+	}
+	return columns, nil
+}
+
+// TableCopyOptions describe what is copied from one table to another.
+type TableCopyOptions struct {
+	Table string
+
+	// Target is the table copied into. Empty means Table.
+	Target string
+
+	// Columns are copied, in order. Empty means every column of Table
+	// the target has too, into the target's column of the same name,
+	// but for the target's generated columns.
+	Columns []ColumnMap
+
+	// Where, if set, is an SQL condition on the source table's columns
+	// choosing the rows copied.
+	Where string
+
+	// Truncate empties the target before copying into it.
+	Truncate bool
+}
+
+// TableCopyResult tells what a table copy did.
+type TableCopyResult struct {
+	Rows int64
+}
+
+// CopyTable streams rows from a table of one server into a table of another,
+// piping COPY TO STDOUT on from into COPY FROM STDIN on to, without an
+// intermediate file. The source session is made read only. The target is
+// written in a single transaction, so a failed copy leaves it as it was.
+func CopyTable(ctx context.Context, from *pgx.Conn, to *pgx.Conn, opts TableCopyOptions) (*TableCopyResult, error) {
+	if opts.Target == "" {
+		opts.Target = opts.Table
+	}
+	if _, err := from.Exec(ctx, "SET default_transaction_read_only = on"); err != nil {
+		return nil, err
+	}
+	if len(opts.Columns) == 0 {
+		columns, err := TableColumns(ctx, from, opts.Table)
+		if err != nil {
+			return nil, err
+		}
+		if len(columns) == 0 {
+			return nil, fmt.Errorf("table %s has no columns", opts.Table)
+		}
+		targetColumns, err := TableColumns(ctx, to, opts.Target)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Columns = sharedColumns(columns, targetColumns); len(opts.Columns) == 0 {
+			return nil, fmt.Errorf("tables %s and %s have no columns in common", opts.Table, opts.Target)
+		}
+	}
+	var source, target []string
+	for _, c := range opts.Columns {
+		source = append(source, c.Source)
+		target = append(target, c.Target)
+	}
+
+	tx, err := to.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	if opts.Truncate {
+		if _, err := tx.Exec(ctx, "TRUNCATE "+quoteTable(opts.Target)); err != nil {
+			return nil, err
+		}
+	}
+
+	// The first side to fail has the cause; the other fails because of it.
+	// Cancelling stops the source even while its query has yet to return
+	// rows.
+	var once sync.Once
+	var cause error
+	fail := func(err error) {
+		once.Do(func() { cause = err })
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r, w := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		_, err := from.PgConn().CopyTo(ctx, w, copyOutSQL(opts.Table, source, opts.Where))
+		if err != nil {
+			fail(fmt.Errorf("reading %s: %w", opts.Table, err))
+		}
+		w.CloseWithError(err)
+		close(done)
+	}()
+
+	tag, err := tx.Conn().PgConn().CopyFrom(ctx, r, copyFromSQL(opts.Target, target))
+	if err != nil {
+		fail(err)
+		r.CloseWithError(err)
+		cancel()
+	}
+	<-done
+	if cause != nil {
+		return nil, cause
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &TableCopyResult{Rows: tag.RowsAffected()}, nil
+}
+
+// sharedColumns maps each of the source columns the target has too onto
+// the target column of the same name, in the source's order. The target's
+// generated columns are left out, as COPY cannot write them.
+func sharedColumns(source []Column, target []Column) []ColumnMap {
+	names := make(map[string]bool, len(target))
+	for _, c := range target {
+		if !c.Generated {
+			names[c.Name] = true
+		}
+	}
+	var columns []ColumnMap
+	for _, c := range source {
+		if names[c.Name] {
+			columns = append(columns, ColumnMap{Source: c.Name, Target: c.Name})
+		}
+	}
+	return columns
+}
+
+// copyOutSQL builds the COPY TO STDOUT statement reading the rows to copy.
+func copyOutSQL(table string, columns []string, where string) string {
+	query := "SELECT " + quoteColumns(columns) + " FROM " + quoteTable(table)
+	if where != "" {
+		query += " WHERE " + parenthesize(where)
+	}
+	return "COPY (" + query + ") TO STDOUT"
+}
@@ -0,0 +1,60 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestParseColumnMap(t *testing.T) {
+	got, err := ParseColumnMap([]string{"id", "full_name:name", "Email"})
+	if err != nil {
+		t.Fatalf("ParseColumnMap() failed: %s", err)
+	}
+	want := []ColumnMap{{"id", "id"}, {"full_name", "name"}, {"Email", "Email"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ParseColumnMap() mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, spec := range []string{"", "a:", ":b", "a:b:c"} {
+		if _, err := ParseColumnMap([]string{spec}); err == nil {
+			t.Errorf("ParseColumnMap(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestSharedColumns(t *testing.T) {
+	source := []Column{{Name: "id"}, {Name: "full_name"}, {Name: "ssn"}, {Name: "email"}}
+	target := []Column{{Name: "email"}, {Name: "id"}, {Name: "full_name", Generated: true}, {Name: "loaded_at"}}
+
+	want := []ColumnMap{{"id", "id"}, {"email", "email"}}
+	if diff := cmp.Diff(want, sharedColumns(source, target)); diff != "" {
+		t.Errorf("sharedColumns() mismatch (-want +got):\n%s", diff)
+	}
+	if got := sharedColumns(source, []Column{{Name: "other"}}); len(got) != 0 {
+		t.Errorf("sharedColumns() of tables with nothing in common = %v, want none", got)
+	}
+}
+
+func TestCopyOutSQL(t *testing.T) {
+	tests := map[string]struct {
+		where string
+		want  string
+	}{
+		"all rows": {"", `COPY (SELECT "id", "full_name" FROM "crm"."people") TO STDOUT`},
+		"where": {"region = 'eu' -- EU only\n",
+			"COPY (SELECT \"id\", \"full_name\" FROM \"crm\".\"people\" WHERE (region = 'eu' -- EU only\n)) TO STDOUT"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := copyOutSQL("crm.people", []string{"id", "full_name"}, tc.where)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("copyOutSQL() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// TransformSpec is one step of a transform pipeline, as written in YAML:
+//
+//	transforms:
+//	  - op: trim
+//	    columns: [name, city]
+//	  - op: replace
+//	    column: phone
+//	    pattern: '[^0-9]'
+//	    value: ''
+//	  - op: concat
+//	    columns: [first, last]
+//	    separator: ' '
+//	    into: full_name
+//
+// Which fields an op uses is listed with the op constants.
+type TransformSpec struct {
+	Op        string
+	Column    string
+	Columns   []string
+	Into      string
+	Pattern   string
+	Value     string
+	Separator string
+	Start     int
+	Length    int
+	Salt      string
+}
+
+// Transform ops. Ops that modify existing columns take Column or Columns and
+// leave NULLs alone; ops that create a column name it with Into.
+const (
+	OpTrim       = "trim"        // trim surrounding whitespace from Column(s)
+	OpUpper      = "upper"       // upper-case Column(s)
+	OpLower      = "lower"       // lower-case Column(s)
+	OpReplace    = "replace"     // replace matches of regexp Pattern in Column(s) with Value
+	OpSubstring  = "substring"   // keep Length characters of Column(s) from 1-based Start; 0 Length keeps the rest
+	OpDefault    = "default"     // set NULL or empty Column(s) to Value
+	OpConcat     = "concat"      // join non-NULL Columns with Separator into Into
+	OpSplit      = "split"       // split Column on Separator into Columns; missing parts are NULL
+	OpHash       = "hash"        // replace Column(s) with the hex SHA-256 of Salt plus the value
+	OpConstant   = "constant"    // set Into to Value
+	OpSourceFile = "source_file" // set Into to the base name of the file being loaded
+	OpLoadTime   = "load_time"   // set Into to the time the load started
+)
+
+// TransformContext carries facts about the load that derived columns use.
+type TransformContext struct {
+	SourceFile string
+	LoadTime   time.Time
+}
+
+// Transformer applies a pipeline of transforms to records.
+type Transformer struct {
+	specs []TransformSpec
+	steps []func(rec *Record)
+}
+
+// ReadTransforms reads transform specs from a YAML document holding a
+// top-level "transforms" list.
+func ReadTransforms(r io.Reader) ([]TransformSpec, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		Transforms []TransformSpec
+	}
+	if err := yaml.UnmarshalStrict(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc.Transforms, nil
+}
+
+// NewTransformer compiles specs into a pipeline run in the order given.
+func NewTransformer(specs []TransformSpec, ctx TransformContext) (*Transformer, error) {
+	t := &Transformer{specs: specs}
+	for i, spec := range specs {
+		step, err := compileTransform(spec, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("transform %d (%s): %s", i+1, spec.Op, err)
+		}
+		t.steps = append(t.steps, step)
+	}
+	return t, nil
+}
+
+// targets returns the columns a spec modifies in place.
+func (spec TransformSpec) targets() []string {
+	if spec.Column != "" {
+		return append([]string{spec.Column}, spec.Columns...)
+	}
+	return spec.Columns
+}
+
+func compileTransform(spec TransformSpec, ctx TransformContext) (func(rec *Record), error) {
+	switch spec.Op {
+	case OpTrim:
+		return mapValues(spec.targets(), strings.TrimSpace)
+	case OpUpper:
+		return mapValues(spec.targets(), strings.ToUpper)
+	case OpLower:
+		return mapValues(spec.targets(), strings.ToLower)
+	case OpReplace:
+		re, err := regexp.Compile(spec.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		return mapValues(spec.targets(), func(s string) string {
+			return re.ReplaceAllString(s, spec.Value)
+		})
+	case OpSubstring:
+		if spec.Start < 1 || spec.Length < 0 {
+			return nil, fmt.Errorf("start must be at least 1 and length not negative")
+		}
+		return mapValues(spec.targets(), func(s string) string {
+			r := []rune(s)
+			if spec.Start > len(r) {
+				return ""
+			}
+			r = r[spec.Start-1:]
+			if spec.Length > 0 && spec.Length < len(r) {
+				r = r[:spec.Length]
+			}
+			return string(r)
+		})
+	case OpHash:
+		return mapValues(spec.targets(), func(s string) string {
+			sum := sha256.Sum256([]byte(spec.Salt + s))
+			return hex.EncodeToString(sum[:])
+		})
+	case OpDefault:
+		cols := spec.targets()
+		if len(cols) == 0 {
+			return nil, fmt.Errorf("no column given")
+		}
+		return func(rec *Record) {
+			for _, col := range cols {
+				if rec.IsNull(col) || rec.Values[col] == "" {
+					rec.Set(col, spec.Value)
+				}
+			}
+		}, nil
+	case OpConcat:
+		if len(spec.Columns) == 0 || spec.Into == "" {
+			return nil, fmt.Errorf("columns and into are required")
+		}
+		return func(rec *Record) {
+			var parts []string
+			for _, col := range spec.Columns {
+				if !rec.IsNull(col) {
+					parts = append(parts, rec.Values[col])
+				}
+			}
+			rec.Set(spec.Into, strings.Join(parts, spec.Separator))
+		}, nil
+	case OpSplit:
+		if spec.Column == "" || len(spec.Columns) == 0 || spec.Separator == "" {
+			return nil, fmt.Errorf("column, columns and separator are required")
+		}
+		return func(rec *Record) {
+			var parts []string
+			if !rec.IsNull(spec.Column) {
+				parts = strings.SplitN(rec.Values[spec.Column], spec.Separator, len(spec.Columns))
+			}
+			for i, col := range spec.Columns {
+				if i < len(parts) {
+					rec.Set(col, parts[i])
+				} else {
+					rec.SetNull(col)
+				}
+			}
+		}, nil
+	case OpConstant:
+		return setValue(spec.Into, spec.Value)
+	case OpSourceFile:
+		return setValue(spec.Into, filepath.Base(ctx.SourceFile))
+	case OpLoadTime:
+		return setValue(spec.Into, ctx.LoadTime.Format("2006-01-02 15:04:05.999999-07:00"))
+	case "":
+		return nil, fmt.Errorf("no op given")
+	}
+	return nil, fmt.Errorf("unknown op")
+}
+
+func mapValues(cols []string, fn func(string) string) (func(rec *Record), error) {
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("no column given")
+	}
+	return func(rec *Record) {
+		for _, col := range cols {
+			if !rec.IsNull(col) {
+				rec.Values[col] = fn(rec.Values[col])
+			}
+		}
+	}, nil
+}
+
+func setValue(col string, value string) (func(rec *Record), error) {
+	if col == "" {
+		return nil, fmt.Errorf("into is required")
+	}
+	return func(rec *Record) {
+		rec.Set(col, value)
+	}, nil
+}
+
+// Columns returns the columns of transformed records, given the columns of
+// the input. Columns created by the pipeline are appended in the order they
+// are first created. It is an error for a transform to use a column that
+// neither the input nor an earlier transform provides.
+func (t *Transformer) Columns(input []string) ([]string, error) {
+	columns := append([]string(nil), input...)
+	known := make(map[string]bool)
+	for _, col := range input {
+		known[col] = true
+	}
+	add := func(col string) {
+		if !known[col] {
+			known[col] = true
+			columns = append(columns, col)
+		}
+	}
+
+	for i, spec := range t.specs {
+		var uses, creates []string
+		switch spec.Op {
+		case OpConcat:
+			uses, creates = spec.Columns, []string{spec.Into}
+		case OpSplit:
+			uses, creates = []string{spec.Column}, spec.Columns
+		case OpConstant, OpSourceFile, OpLoadTime:
+			creates = []string{spec.Into}
+		default:
+			uses = spec.targets()
+		}
+		for _, col := range uses {
+			if !known[col] {
+				return nil, fmt.Errorf("transform %d (%s): unknown column '%s'", i+1, spec.Op, col)
+			}
+		}
+		for _, col := range creates {
+			add(col)
+		}
+	}
+
+	return columns, nil
+}
+
+// Transform runs the pipeline over rec, modifying it in place.
+func (t *Transformer) Transform(rec *Record) {
+	for _, step := range t.steps {
+		step(rec)
+	}
+}
+
+// TransformingReader transforms each record read from a RecordReader.
+type TransformingReader struct {
+	source      RecordReader
+	transformer *Transformer
+}
+
+func NewTransformingReader(source RecordReader, transformer *Transformer) *TransformingReader {
+	return &TransformingReader{source: source, transformer: transformer}
+}
+
+func (r *TransformingReader) Read() (*Record, error) {
+	rec, err := r.source.Read()
+	if err != nil {
+		return nil, err
+	}
+	r.transformer.Transform(rec)
+	return rec, nil
+}
@@ -0,0 +1,189 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransformerTransform(t *testing.T) {
+
+	ctx := TransformContext{SourceFile: "/data/vendor_20200229.csv", LoadTime: time.Date(2020, 2, 29, 13, 5, 0, 0, time.UTC)}
+
+	tests := map[string]struct {
+		specs []TransformSpec
+		in    *Record
+		want  *Record
+	}{
+		"trim upper lower": {
+			[]TransformSpec{
+				{Op: OpTrim, Columns: []string{"a", "b"}},
+				{Op: OpUpper, Column: "a"},
+				{Op: OpLower, Column: "b"},
+			},
+			&Record{Values: map[string]string{"a": " x ", "b": " Y "}},
+			&Record{Values: map[string]string{"a": "X", "b": "y"}},
+		},
+		"replace": {
+			[]TransformSpec{{Op: OpReplace, Column: "phone", Pattern: `[^0-9]`}},
+			&Record{Values: map[string]string{"phone": "(555) 123-4567"}},
+			&Record{Values: map[string]string{"phone": "5551234567"}},
+		},
+		"substring": {
+			[]TransformSpec{
+				{Op: OpSubstring, Column: "zip", Start: 1, Length: 5},
+				{Op: OpSubstring, Column: "tail", Start: 3},
+			},
+			&Record{Values: map[string]string{"zip": "12345-6789", "tail": "ab€d"}},
+			&Record{Values: map[string]string{"zip": "12345", "tail": "€d"}},
+		},
+		"default": {
+			[]TransformSpec{{Op: OpDefault, Columns: []string{"a", "b", "c"}, Value: "US"}},
+			&Record{Values: map[string]string{"a": "", "b": "", "c": "CA"}, Nulls: map[string]bool{"b": true}},
+			&Record{Values: map[string]string{"a": "US", "b": "US", "c": "CA"}, Nulls: map[string]bool{}},
+		},
+		"nulls untouched": {
+			[]TransformSpec{{Op: OpUpper, Column: "a"}, {Op: OpHash, Column: "a"}},
+			&Record{Values: map[string]string{"a": ""}, Nulls: map[string]bool{"a": true}},
+			&Record{Values: map[string]string{"a": ""}, Nulls: map[string]bool{"a": true}},
+		},
+		"concat skips nulls": {
+			[]TransformSpec{{Op: OpConcat, Columns: []string{"first", "middle", "last"}, Separator: " ", Into: "name"}},
+			&Record{Values: map[string]string{"first": "Ada", "middle": "", "last": "Lovelace"}, Nulls: map[string]bool{"middle": true}},
+			&Record{Values: map[string]string{"first": "Ada", "middle": "", "last": "Lovelace", "name": "Ada Lovelace"}, Nulls: map[string]bool{"middle": true}},
+		},
+		"split": {
+			[]TransformSpec{{Op: OpSplit, Column: "name", Separator: " ", Columns: []string{"first", "last", "suffix"}}},
+			&Record{Values: map[string]string{"name": "Ada King Lovelace"}},
+			&Record{Values: map[string]string{"name": "Ada King Lovelace", "first": "Ada", "last": "King", "suffix": "Lovelace"}},
+		},
+		"split short": {
+			[]TransformSpec{{Op: OpSplit, Column: "name", Separator: " ", Columns: []string{"first", "last"}}},
+			&Record{Values: map[string]string{"name": "Cher"}},
+			&Record{Values: map[string]string{"name": "Cher", "first": "Cher", "last": ""}, Nulls: map[string]bool{"last": true}},
+		},
+		"hash": {
+			[]TransformSpec{{Op: OpHash, Column: "ssn", Salt: "pepper"}},
+			&Record{Values: map[string]string{"ssn": "123-45-6789"}},
+			&Record{Values: map[string]string{"ssn": "db0c794283a81a650b113847473f398c7fde98aa7f6ce9c6d3298559c4210d2c"}},
+		},
+		"derived columns": {
+			[]TransformSpec{
+				{Op: OpConstant, Into: "vendor", Value: "acme"},
+				{Op: OpSourceFile, Into: "file"},
+				{Op: OpLoadTime, Into: "loaded"},
+			},
+			&Record{Values: map[string]string{}},
+			&Record{Values: map[string]string{"vendor": "acme", "file": "vendor_20200229.csv", "loaded": "2020-02-29 13:05:00+00:00"}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tr, err := NewTransformer(tc.specs, ctx)
+			if err != nil {
+				t.Fatalf("NewTransformer() failed: %s", err)
+			}
+
+			tr.Transform(tc.in)
+
+			if diff := cmp.Diff(tc.want, tc.in); diff != "" {
+				t.Errorf("Transform() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewTransformerErrors(t *testing.T) {
+
+	tests := map[string]struct {
+		spec TransformSpec
+		err  error
+	}{
+		"no op":         {TransformSpec{Column: "a"}, errors.New("transform 1 (): no op given")},
+		"unknown op":    {TransformSpec{Op: "explode", Column: "a"}, errors.New("transform 1 (explode): unknown op")},
+		"no column":     {TransformSpec{Op: OpTrim}, errors.New("transform 1 (trim): no column given")},
+		"bad pattern":   {TransformSpec{Op: OpReplace, Column: "a", Pattern: "("}, errors.New("transform 1 (replace): error parsing regexp: missing closing ): `(`")},
+		"bad substring": {TransformSpec{Op: OpSubstring, Column: "a"}, errors.New("transform 1 (substring): start must be at least 1 and length not negative")},
+		"concat into":   {TransformSpec{Op: OpConcat, Columns: []string{"a"}}, errors.New("transform 1 (concat): columns and into are required")},
+		"constant into": {TransformSpec{Op: OpConstant, Value: "x"}, errors.New("transform 1 (constant): into is required")},
+	}
+
+	equateErrorMessage := cmp.Comparer(func(x, y error) bool {
+		if x == nil || y == nil {
+			return x == nil && y == nil
+		}
+		return x.Error() == y.Error()
+	})
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewTransformer([]TransformSpec{tc.spec}, TransformContext{})
+
+			if diff := cmp.Diff(tc.err, err, equateErrorMessage); diff != "" {
+				t.Errorf("Error mismatch for NewTransformer() (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTransformerColumns(t *testing.T) {
+	specs := []TransformSpec{
+		{Op: OpSplit, Column: "name", Separator: " ", Columns: []string{"first", "last"}},
+		{Op: OpConcat, Columns: []string{"last", "first"}, Separator: ", ", Into: "sort_name"},
+		{Op: OpConstant, Into: "first", Value: "overwritten"},
+		{Op: OpSourceFile, Into: "file"},
+	}
+	tr, err := NewTransformer(specs, TransformContext{})
+	if err != nil {
+		t.Fatalf("NewTransformer() failed: %s", err)
+	}
+
+	got, err := tr.Columns([]string{"id", "name"})
+	if err != nil {
+		t.Fatalf("Columns() failed: %s", err)
+	}
+
+	want := []string{"id", "name", "first", "last", "sort_name", "file"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Columns() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := tr.Columns([]string{"id"}); err == nil {
+		t.Errorf("Columns() of input lacking 'name' succeeded, want error")
+	}
+}
+
+func TestReadTransforms(t *testing.T) {
+	doc := `
+transforms:
+  - op: trim
+    columns: [name, city]
+  - op: concat
+    columns: [first, last]
+    separator: ' '
+    into: full_name
+`
+	got, err := ReadTransforms(strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("ReadTransforms() failed: %s", err)
+	}
+
+	want := []TransformSpec{
+		{Op: OpTrim, Columns: []string{"name", "city"}},
+		{Op: OpConcat, Columns: []string{"first", "last"}, Separator: " ", Into: "full_name"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ReadTransforms() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := ReadTransforms(strings.NewReader("transforms:\n  - op: trim\n    colums: [a]\n")); err == nil {
+		t.Errorf("ReadTransforms() accepted a misspelled field, want error")
+	}
+}
@@ -0,0 +1,293 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"strconv"
+	"strings"
+)
+
+// stageTable is the temporary table records are copied into before being
+// merged into the target.
+const stageTable = "pghurler_stage"
+
+// stageLine is the column of the staging table holding the line each record
+// starts on, to tell where a duplicate key is.
+const stageLine = "pghurler_line"
+
+// upsertRecords copies the records into a staging table, then merges them
+// into the target with INSERT ... ON CONFLICT.
+func upsertRecords(ctx context.Context, tx pgx.Tx, src *Source, opts LoadOptions) (*LoadResult, error) {
+	columns := src.Columns()
+
+	rows, err := stageRecords(ctx, tx, opts.Table, columns, src)
+	if err != nil {
+		return nil, err
+	}
+	// ON CONFLICT cannot update a row twice, so a key the file repeats
+	// would fail the merge without telling which.
+	if err := checkUnique(ctx, tx, stageTable, opts.Key); err != nil {
+		return nil, err
+	}
+
+	res := &LoadResult{Rows: rows}
+	err = tx.QueryRow(ctx, upsertSQL(opts.Table, stageTable, columns, opts)).Scan(&res.Inserted, &res.Updated)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Deletes != "" {
+		if res.Deleted, err = deleteAbsent(ctx, tx, opts); err != nil {
+			return nil, err
+		}
+	}
+	if err := dropStage(ctx, tx); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// deleteAbsent deletes the target rows whose key is not in the staging table,
+// unless they are more than opts.MaxDeletePercent of the table.
+func deleteAbsent(ctx context.Context, tx pgx.Tx, opts LoadOptions) (int64, error) {
+	var absent, total int64
+	if err := tx.QueryRow(ctx, countAbsentSQL(opts.Table, stageTable, opts)).Scan(&absent, &total); err != nil {
+		return 0, err
+	}
+	if absent > 0 && float64(absent)*100 > opts.MaxDeletePercent*float64(total) {
+		return 0, &DeleteLimitError{Absent: absent, Total: total, MaxPercent: opts.MaxDeletePercent}
+	}
+
+	tag, err := tx.Exec(ctx, deleteAbsentSQL(opts.Table, stageTable, opts))
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// DeleteLimitError reports that more rows are absent from a snapshot than
+// may be deleted, which usually means the file was truncated.
+type DeleteLimitError struct {
+	Absent     int64
+	Total      int64
+	MaxPercent float64
+}
+
+func (e *DeleteLimitError) Error() string {
+	return fmt.Sprintf("%d of %d rows (%.1f%%) are absent from the file, more than the %g%% allowed to be deleted",
+		e.Absent, e.Total, float64(e.Absent)*100/float64(e.Total), e.MaxPercent)
+}
+
+// absentCondition matches target rows, aliased t, whose key is not in the
+// staging table.
+func absentCondition(stage string, key []string) string {
+	match := make([]string, len(key))
+	for i, col := range key {
+		q := pgx.Identifier{col}.Sanitize()
+		match[i] = "s." + q + " = t." + q
+	}
+	return "NOT EXISTS (SELECT 1 FROM " + quoteTable(stage) + " s WHERE " + strings.Join(match, " AND ") + ")"
+}
+
+// liveCondition matches the target rows, aliased t, that are not already
+// soft deleted.
+func liveCondition(opts LoadOptions) string {
+	if opts.Deletes != DeleteSoft {
+		return "true"
+	}
+	return "t." + pgx.Identifier{opts.deletedAt()}.Sanitize() + " IS NULL"
+}
+
+func countAbsentSQL(table string, stage string, opts LoadOptions) string {
+	return "SELECT count(*) FILTER (WHERE " + absentCondition(stage, opts.Key) + "), count(*) FROM " +
+		quoteTable(table) + " t WHERE " + liveCondition(opts)
+}
+
+func deleteAbsentSQL(table string, stage string, opts LoadOptions) string {
+	where := " WHERE " + liveCondition(opts) + " AND " + absentCondition(stage, opts.Key)
+	if opts.Deletes == DeleteSoft {
+		return "UPDATE " + quoteTable(table) + " t SET " + pgx.Identifier{opts.deletedAt()}.Sanitize() + " = now()" + where
+	}
+	return "DELETE FROM " + quoteTable(table) + " t" + where
+}
+
+// stageRecords creates the staging table with the types of the target's
+// columns, and stageLine, and copies the records into it. The table is
+// dropped on commit, unless dropStage drops it before.
+func stageRecords(ctx context.Context, tx pgx.Tx, table string, columns []string, records RecordReader) (int64, error) {
+	return stageSelected(ctx, tx, table, quoteColumns(columns), columns, records)
+}
+
+// stageSelected creates the staging table from list, a select list of the
+// target's columns, and stageLine, and copies the given columns of the
+// records into it.
+func stageSelected(ctx context.Context, tx pgx.Tx, table string, list string, columns []string, records RecordReader) (int64, error) {
+	create := "CREATE TEMPORARY TABLE " + quoteTable(stageTable) + " ON COMMIT DROP AS SELECT " +
+		list + ", NULL::bigint AS " + pgx.Identifier{stageLine}.Sanitize() +
+		" FROM " + quoteTable(table) + " WITH NO DATA"
+	if _, err := tx.Exec(ctx, create); err != nil {
+		return 0, err
+	}
+	staged := append(append([]string(nil), columns...), stageLine)
+	return copyRecords(ctx, tx.Conn().PgConn(), stageTable, staged, lineReader{records})
+}
+
+// dropStage drops the staging table once it is merged, so that another file
+// loaded in the same transaction can stage its records in turn.
+func dropStage(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, "DROP TABLE "+quoteTable(stageTable))
+	return err
+}
+
+// lineReader sets the stageLine value of each record read from a
+// RecordReader.
+type lineReader struct {
+	source RecordReader
+}
+
+func (r lineReader) Read() (*Record, error) {
+	rec, err := r.source.Read()
+	if err != nil {
+		return nil, err
+	}
+	rec.Values[stageLine] = strconv.FormatUint(rec.LineNumber, 10)
+	return rec, nil
+}
+
+// checkUnique fails with a DuplicateKeyError if key is not unique in table.
+// In the staging table, the error tells the lines of the file the key is on.
+func checkUnique(ctx context.Context, tx pgx.Tx, table string, key []string) error {
+	staged := table == stageTable
+	dup := &DuplicateKeyError{In: table, Key: make([]*string, len(key))}
+	dest := make([]interface{}, 0, len(key)+2)
+	for i := range key {
+		dest = append(dest, &dup.Key[i])
+	}
+	dest = append(dest, &dup.Count)
+	if staged {
+		dup.In = "the file"
+		dest = append(dest, &dup.Lines)
+	}
+	err := tx.QueryRow(ctx, uniqueSQL(table, key, staged)).Scan(dest...)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return dup
+}
+
+// duplicateLines is the number of lines a DuplicateKeyError lists.
+const duplicateLines = 10
+
+// uniqueSQL finds a key that is in table more than once, with its count and,
+// if lines is set, the first lines it is on.
+func uniqueSQL(table string, key []string, lines bool) string {
+	list := textColumns("", key) + ", count(*)"
+	if lines {
+		line := pgx.Identifier{stageLine}.Sanitize()
+		list += fmt.Sprintf(", (array_agg(%s ORDER BY %s))[1:%d]", line, line, duplicateLines)
+	}
+	return "SELECT " + list + " FROM " + quoteTable(table) + " GROUP BY " + quoteColumns(key) + " HAVING count(*) > 1 LIMIT 1"
+}
+
+// DuplicateKeyError reports a key found more than once in the file or the
+// table, so records cannot be paired with rows or merged into them by it.
+type DuplicateKeyError struct {
+	In    string // "the file" or the name of the table
+	Key   []*string
+	Count int64
+
+	// Lines are the first lines of the file the key is on, if it is in
+	// the file.
+	Lines []uint64
+}
+
+func (e *DuplicateKeyError) Error() string {
+	msg := fmt.Sprintf("key (%s) is in %s %d times", keyText(e.Key), e.In, e.Count)
+	if len(e.Lines) == 0 {
+		return msg
+	}
+	lines := make([]string, len(e.Lines))
+	for i, n := range e.Lines {
+		lines[i] = fmt.Sprint(n)
+	}
+	if int64(len(lines)) < e.Count {
+		lines = append(lines, "...")
+	}
+	return msg + ", on lines " + strings.Join(lines, ", ")
+}
+
+// keyText joins the values of a key, writing NULL for nil.
+func keyText(key []*string) string {
+	values := make([]string, len(key))
+	for i, v := range key {
+		if v == nil {
+			values[i] = "NULL"
+		} else {
+			values[i] = *v
+		}
+	}
+	return strings.Join(values, ", ")
+}
+
+// upsertSQL merges the staging table into the target and counts the rows
+// inserted and updated. A row whose xmax is zero was freshly inserted rather
+// than updated.
+func upsertSQL(table string, stage string, columns []string, opts LoadOptions) string {
+	var set, old, excluded []string
+	for _, col := range columns {
+		if contains(opts.Key, col) {
+			continue
+		}
+		q := pgx.Identifier{col}.Sanitize()
+		set = append(set, q+" = EXCLUDED."+q)
+		if contains(LineageColumns, col) {
+			// Lineage differs on every load and is no change of the row.
+			continue
+		}
+		old = append(old, "t."+q)
+		excluded = append(excluded, "EXCLUDED."+q)
+	}
+
+	var b strings.Builder
+	b.WriteString("WITH merged AS (INSERT INTO " + quoteTable(table) + " AS t (" + quoteColumns(columns) + ")")
+	b.WriteString(" SELECT " + quoteColumns(columns) + " FROM " + quoteTable(stage))
+	b.WriteString(" ON CONFLICT (" + quoteColumns(opts.Key) + ")")
+
+	// Rows that come back after being soft deleted are revived.
+	var revive string
+	if opts.Deletes == DeleteSoft {
+		q := pgx.Identifier{opts.deletedAt()}.Sanitize()
+		set = append(set, q+" = NULL")
+		revive = " OR t." + q + " IS NOT NULL"
+	}
+
+	var changed string
+	if opts.OnlyChanged {
+		switch {
+		case len(old) > 0:
+			changed = " WHERE (" + strings.Join(old, ", ") + ") IS DISTINCT FROM (" + strings.Join(excluded, ", ") + ")" + revive
+		case revive != "":
+			changed = " WHERE" + strings.TrimPrefix(revive, " OR")
+		default:
+			// Nothing but lineage could change.
+			set = nil
+		}
+	}
+
+	if len(set) == 0 {
+		b.WriteString(" DO NOTHING")
+	} else {
+		b.WriteString(" DO UPDATE SET " + strings.Join(set, ", ") + changed)
+	}
+	b.WriteString(" RETURNING xmax = 0 AS inserted)")
+	b.WriteString(" SELECT count(*) FILTER (WHERE inserted), count(*) FILTER (WHERE NOT inserted) FROM merged")
+	return b.String()
+}
@@ -0,0 +1,176 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"context"
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"os"
+	"testing"
+)
+
+func TestUpsertSQL(t *testing.T) {
+
+	tests := map[string]struct {
+		columns []string
+		opts    LoadOptions
+		want    string
+	}{
+		"update all": {
+			[]string{"id", "name", "amount"},
+			LoadOptions{Key: []string{"id"}},
+			`WITH merged AS (INSERT INTO "events" AS t ("id", "name", "amount") SELECT "id", "name", "amount" FROM "pghurler_stage"` +
+				` ON CONFLICT ("id") DO UPDATE SET "name" = EXCLUDED."name", "amount" = EXCLUDED."amount" RETURNING xmax = 0 AS inserted)` +
+				` SELECT count(*) FILTER (WHERE inserted), count(*) FILTER (WHERE NOT inserted) FROM merged`,
+		},
+		"only changed": {
+			[]string{"region", "id", "amount"},
+			LoadOptions{Key: []string{"region", "id"}, OnlyChanged: true},
+			`WITH merged AS (INSERT INTO "events" AS t ("region", "id", "amount") SELECT "region", "id", "amount" FROM "pghurler_stage"` +
+				` ON CONFLICT ("region", "id") DO UPDATE SET "amount" = EXCLUDED."amount" WHERE (t."amount") IS DISTINCT FROM (EXCLUDED."amount") RETURNING xmax = 0 AS inserted)` +
+				` SELECT count(*) FILTER (WHERE inserted), count(*) FILTER (WHERE NOT inserted) FROM merged`,
+		},
+		"key only": {
+			[]string{"id"},
+			LoadOptions{Key: []string{"id"}, OnlyChanged: true},
+			`WITH merged AS (INSERT INTO "events" AS t ("id") SELECT "id" FROM "pghurler_stage"` +
+				` ON CONFLICT ("id") DO NOTHING RETURNING xmax = 0 AS inserted)` +
+				` SELECT count(*) FILTER (WHERE inserted), count(*) FILTER (WHERE NOT inserted) FROM merged`,
+		},
+		"lineage": {
+			[]string{"id", "amount", "_load_id"},
+			LoadOptions{Key: []string{"id"}, OnlyChanged: true},
+			`WITH merged AS (INSERT INTO "events" AS t ("id", "amount", "_load_id") SELECT "id", "amount", "_load_id" FROM "pghurler_stage"` +
+				` ON CONFLICT ("id") DO UPDATE SET "amount" = EXCLUDED."amount", "_load_id" = EXCLUDED."_load_id"` +
+				` WHERE (t."amount") IS DISTINCT FROM (EXCLUDED."amount") RETURNING xmax = 0 AS inserted)` +
+				` SELECT count(*) FILTER (WHERE inserted), count(*) FILTER (WHERE NOT inserted) FROM merged`,
+		},
+		"key and lineage only": {
+			[]string{"id", "_load_id"},
+			LoadOptions{Key: []string{"id"}, OnlyChanged: true},
+			`WITH merged AS (INSERT INTO "events" AS t ("id", "_load_id") SELECT "id", "_load_id" FROM "pghurler_stage"` +
+				` ON CONFLICT ("id") DO NOTHING RETURNING xmax = 0 AS inserted)` +
+				` SELECT count(*) FILTER (WHERE inserted), count(*) FILTER (WHERE NOT inserted) FROM merged`,
+		},
+		"soft deletes": {
+			[]string{"id", "amount"},
+			LoadOptions{Key: []string{"id"}, OnlyChanged: true, Deletes: DeleteSoft},
+			`WITH merged AS (INSERT INTO "events" AS t ("id", "amount") SELECT "id", "amount" FROM "pghurler_stage"` +
+				` ON CONFLICT ("id") DO UPDATE SET "amount" = EXCLUDED."amount", "deleted_at" = NULL` +
+				` WHERE (t."amount") IS DISTINCT FROM (EXCLUDED."amount") OR t."deleted_at" IS NOT NULL RETURNING xmax = 0 AS inserted)` +
+				` SELECT count(*) FILTER (WHERE inserted), count(*) FILTER (WHERE NOT inserted) FROM merged`,
+		},
+		"soft deletes key only": {
+			[]string{"id"},
+			LoadOptions{Key: []string{"id"}, OnlyChanged: true, Deletes: DeleteSoft, DeletedAt: "gone"},
+			`WITH merged AS (INSERT INTO "events" AS t ("id") SELECT "id" FROM "pghurler_stage"` +
+				` ON CONFLICT ("id") DO UPDATE SET "gone" = NULL WHERE t."gone" IS NOT NULL RETURNING xmax = 0 AS inserted)` +
+				` SELECT count(*) FILTER (WHERE inserted), count(*) FILTER (WHERE NOT inserted) FROM merged`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := upsertSQL("events", stageTable, tc.columns, tc.opts)
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("upsertSQL() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDeleteAbsentSQL(t *testing.T) {
+	absent := `NOT EXISTS (SELECT 1 FROM "pghurler_stage" s WHERE s."region" = t."region" AND s."id" = t."id")`
+
+	tests := map[string]struct {
+		opts  LoadOptions
+		count string
+		del   string
+	}{
+		"hard": {
+			LoadOptions{Key: []string{"region", "id"}, Deletes: DeleteHard},
+			`SELECT count(*) FILTER (WHERE ` + absent + `), count(*) FROM "events" t WHERE true`,
+			`DELETE FROM "events" t WHERE true AND ` + absent,
+		},
+		"soft": {
+			LoadOptions{Key: []string{"region", "id"}, Deletes: DeleteSoft},
+			`SELECT count(*) FILTER (WHERE ` + absent + `), count(*) FROM "events" t WHERE t."deleted_at" IS NULL`,
+			`UPDATE "events" t SET "deleted_at" = now() WHERE t."deleted_at" IS NULL AND ` + absent,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.count, countAbsentSQL("events", stageTable, tc.opts)); diff != "" {
+				t.Errorf("countAbsentSQL() mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.del, deleteAbsentSQL("events", stageTable, tc.opts)); diff != "" {
+				t.Errorf("deleteAbsentSQL() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDuplicateKeyError(t *testing.T) {
+	region, id := "eu", "7"
+	tests := map[string]struct {
+		err  *DuplicateKeyError
+		want string
+	}{
+		"table":     {&DuplicateKeyError{In: "events", Key: []*string{&region, &id, nil}, Count: 3}, "key (eu, 7, NULL) is in events 3 times"},
+		"lines":     {&DuplicateKeyError{In: "the file", Key: []*string{&id}, Count: 2, Lines: []uint64{2, 9}}, "key (7) is in the file 2 times, on lines 2, 9"},
+		"more than": {&DuplicateKeyError{In: "the file", Key: []*string{&id}, Count: 3, Lines: []uint64{2, 9}}, "key (7) is in the file 3 times, on lines 2, 9, ..."},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tc.err.Error(); got != tc.want {
+				t.Errorf("DuplicateKeyError.Error() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUniqueSQL(t *testing.T) {
+	want := `SELECT "region"::text, "id"::text, count(*) FROM "events" GROUP BY "region", "id" HAVING count(*) > 1 LIMIT 1`
+	if diff := cmp.Diff(want, uniqueSQL("events", []string{"region", "id"}, false)); diff != "" {
+		t.Errorf("uniqueSQL() mismatch (-want +got):\n%s", diff)
+	}
+
+	want = `SELECT "id"::text, count(*), (array_agg("pghurler_line" ORDER BY "pghurler_line"))[1:10] FROM "pghurler_stage"` +
+		` GROUP BY "id" HAVING count(*) > 1 LIMIT 1`
+	if diff := cmp.Diff(want, uniqueSQL(stageTable, []string{"id"}, true)); diff != "" {
+		t.Errorf("uniqueSQL() with lines mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestUpsertDuplicateKey(t *testing.T) {
+	ctx := context.Background()
+	conn := testConn(t)
+	defer conn.Close(ctx)
+
+	if _, err := conn.Exec(ctx, "CREATE TEMPORARY TABLE upsert_events (id int PRIMARY KEY, name text)"); err != nil {
+		t.Fatalf("failed to create table: %s", err)
+	}
+	path := testFile(t, "*.csv", "id,name\n1,ada\n2,grace\n1,alan\n")
+	defer os.Remove(path) // clean up
+
+	src, err := OpenSource(path, SourceOptions{})
+	if err != nil {
+		t.Fatalf("OpenSource() failed: %s", err)
+	}
+	defer src.Close()
+
+	_, err = Load(ctx, conn, src, LoadOptions{Table: "upsert_events", Mode: ModeUpsert, Key: []string{"id"}})
+	var dup *DuplicateKeyError
+	if !errors.As(err, &dup) {
+		t.Fatalf("Load() error = %v, want a DuplicateKeyError", err)
+	}
+	if want := "key (1) is in the file 2 times, on lines 2, 4"; dup.Error() != want {
+		t.Errorf("Load() error = %q, want %q", dup.Error(), want)
+	}
+}
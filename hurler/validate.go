@@ -0,0 +1,290 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Column is a column of a target table, as the catalog describes it.
+type Column struct {
+	Name       string
+	Type       string // as format_type gives it, e.g. numeric(10,2)
+	NotNull    bool
+	HasDefault bool
+	Generated  bool // a stored generated column, which COPY cannot write
+
+	// The type's input function, which COPY parses values with, and its
+	// arguments.
+	input   string
+	nargs   int
+	ioparam uint32
+	typmod  int32
+}
+
+// tableColumnsSQL reads attgenerated through to_jsonb, as servers before
+// Postgres 12 lack it.
+const tableColumnsSQL = `SELECT a.attname, format_type(a.atttypid, a.atttypmod), a.attnotnull,
+	a.atthasdef OR a.attidentity <> '', coalesce(to_jsonb(a) ->> 'attgenerated', '') <> '',
+	t.typinput::regproc::text, p.pronargs,
+	CASE WHEN t.typelem <> 0 THEN t.typelem ELSE t.oid END, a.atttypmod
+FROM pg_attribute a
+JOIN pg_type t ON t.oid = a.atttypid
+JOIN pg_proc p ON p.oid = t.typinput
+WHERE a.attrelid = $1::text::regclass AND a.attnum > 0 AND NOT a.attisdropped
+ORDER BY a.attnum`
+
+// TableColumns reads the columns of table from the catalog.
+func TableColumns(ctx context.Context, conn *pgx.Conn, table string) ([]Column, error) {
+	rows, err := conn.Query(ctx, tableColumnsSQL, quoteTable(table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []Column
+	for rows.Next() {
+		var c Column
+		if err := rows.Scan(&c.Name, &c.Type, &c.NotNull, &c.HasDefault, &c.Generated, &c.input, &c.nargs, &c.ioparam, &c.typmod); err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
+
+// inputSQL counts the values of the text array $1 that the column's input
+// function accepts, failing on the first it rejects.
+func inputSQL(c Column) string {
+	call := c.input + "(v::cstring"
+	if c.nargs == 3 {
+		call += fmt.Sprintf(", %d, %d", c.ioparam, c.typmod)
+	}
+	return "SELECT count(" + call + ")) FROM unnest($1::text[]) AS v"
+}
+
+// Problem is something in a file that would fail its load.
+type Problem struct {
+	Line    uint64 // zero for a problem of the file as a whole
+	Column  string // empty unless the problem is with one column
+	Message string
+}
+
+func (p Problem) String() string {
+	switch {
+	case p.Line > 0 && p.Column != "":
+		return fmt.Sprintf("line %d, column %q: %s", p.Line, p.Column, p.Message)
+	case p.Line > 0:
+		return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+	case p.Column != "":
+		return fmt.Sprintf("column %q: %s", p.Column, p.Message)
+	}
+	return p.Message
+}
+
+// ValidateOptions describe how a file is validated.
+type ValidateOptions struct {
+	// MaxProblems stops the validation once this many problems are found.
+	// Zero means no limit.
+	MaxProblems int
+
+	// BatchSize is the number of values of a column sent to the server to
+	// be checked at once. Zero means 1000.
+	BatchSize int
+}
+
+// Validation is the outcome of validating a file.
+type Validation struct {
+	Records  int64
+	Problems []Problem
+
+	// Stopped tells that MaxProblems were found before the end of the
+	// file, so there may be more.
+	Stopped bool
+}
+
+// ValidateSource reads every record of src as a load into table would, and
+// reports all that would fail it, with their line: records that do not
+// parse or convert, columns the table lacks or needs, NULLs in NOT NULL
+// columns, values the column types reject, and control totals that do not
+// match. Values are checked by the input functions of their column types,
+// which COPY uses too, so the connection is only read from. Constraints
+// other than NOT NULL are not checked.
+func ValidateSource(ctx context.Context, conn *pgx.Conn, src *Source, table string, opts ValidateOptions) (*Validation, error) {
+	if _, err := conn.Exec(ctx, "SET default_transaction_read_only = on"); err != nil {
+		return nil, err
+	}
+	columns, err := TableColumns(ctx, conn, table)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s has no columns", table)
+	}
+
+	v := &validator{ctx: ctx, conn: conn, opts: opts, res: &Validation{}}
+	if v.opts.BatchSize <= 0 {
+		v.opts.BatchSize = 1000
+	}
+
+	target := make(map[string]Column)
+	for _, c := range columns {
+		target[c.Name] = c
+	}
+	for _, name := range src.Columns() {
+		c, ok := target[name]
+		if !ok {
+			v.add(Problem{Column: name, Message: fmt.Sprintf("not a column of %s", table)})
+			continue
+		}
+		v.columns = append(v.columns, &columnBatch{Column: c})
+	}
+	for _, c := range columns {
+		if c.NotNull && !c.HasDefault && !contains(src.Columns(), c.Name) {
+			v.add(Problem{Column: c.Name, Message: "is NOT NULL without a default, but not in the file"})
+		}
+	}
+
+	if err := v.read(src); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(v.res.Problems, func(i, j int) bool {
+		return v.res.Problems[i].Line < v.res.Problems[j].Line
+	})
+	return v.res, nil
+}
+
+// validator collects the problems of a file.
+type validator struct {
+	ctx     context.Context
+	conn    *pgx.Conn
+	opts    ValidateOptions
+	columns []*columnBatch
+	res     *Validation
+}
+
+// columnBatch holds values of a column waiting to be checked, with their
+// lines.
+type columnBatch struct {
+	Column
+	values []string
+	lines  []uint64
+}
+
+func (v *validator) add(p Problem) {
+	v.res.Problems = append(v.res.Problems, p)
+}
+
+func (v *validator) full() bool {
+	return v.opts.MaxProblems > 0 && len(v.res.Problems) >= v.opts.MaxProblems
+}
+
+// read checks every record of src, going on past records that fail to
+// parse or convert.
+func (v *validator) read(src *Source) error {
+	for !v.full() {
+		rec, err := src.Read()
+		if err == io.EOF {
+			for _, c := range v.columns {
+				if err := v.check(c); err != nil {
+					return err
+				}
+			}
+			if err := src.Validate(); err != nil && !v.full() {
+				v.add(Problem{Message: err.Error()})
+			}
+			return nil
+		}
+
+		if p, ok := recordProblem(err); ok {
+			v.add(p)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		v.res.Records++
+		for _, c := range v.columns {
+			if rec.IsNull(c.Name) {
+				if c.NotNull {
+					v.add(Problem{Line: rec.LineNumber, Column: c.Name, Message: "NULL in a NOT NULL column"})
+				}
+				continue
+			}
+			c.values = append(c.values, rec.Values[c.Name])
+			c.lines = append(c.lines, rec.LineNumber)
+			if len(c.values) >= v.opts.BatchSize {
+				if err := v.check(c); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	v.res.Stopped = true
+	return nil
+}
+
+// recordProblem turns an error reading a record that leaves the records
+// after it readable, one that does not parse or convert, into a Problem.
+func recordProblem(err error) (Problem, bool) {
+	var parseErr *csv.ParseError
+	var convErr *ConversionError
+	switch {
+	case errors.As(err, &convErr):
+		return Problem{Line: convErr.LineNumber, Column: convErr.Column, Message: fmt.Sprintf("cannot convert %q to %s: %s", convErr.Value, convErr.Type, convErr.Err)}, true
+	case errors.As(err, &parseErr):
+		return Problem{Line: uint64(parseErr.StartLine), Message: parseErr.Err.Error()}, true
+	}
+	return Problem{}, false
+}
+
+// check sends the values waiting in c to the server and empties it.
+func (v *validator) check(c *columnBatch) error {
+	err := v.checkValues(c.Column, c.values, c.lines)
+	c.values, c.lines = c.values[:0], c.lines[:0]
+	return err
+}
+
+// checkValues has the server check values, halving a batch that fails
+// until each value rejected is found.
+func (v *validator) checkValues(c Column, values []string, lines []uint64) error {
+	if len(values) == 0 || v.full() {
+		return nil
+	}
+	var n int64
+	err := v.conn.QueryRow(v.ctx, inputSQL(c), values).Scan(&n)
+	if err == nil {
+		return nil
+	}
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || !rejectsValue(pgErr) {
+		return err
+	}
+
+	if len(values) == 1 {
+		v.add(Problem{Line: lines[0], Column: c.Name, Message: fmt.Sprintf("%q is not a valid %s: %s", values[0], c.Type, pgErr.Message)})
+		return nil
+	}
+	half := len(values) / 2
+	if err := v.checkValues(c, values[:half], lines[:half]); err != nil {
+		return err
+	}
+	return v.checkValues(c, values[half:], lines[half:])
+}
+
+// rejectsValue tells an error of a bad value, a data exception or a
+// domain's check constraint, from other failures.
+func rejectsValue(err *pgconn.PgError) bool {
+	return strings.HasPrefix(err.Code, "22") || err.Code == "23514"
+}
@@ -0,0 +1,106 @@
+/*
+Copyright © 2019 Jason Walker <ragin.jason@me.com>
+This file is part of pghurler.
+*/
+package hurler
+
+import (
+	"context"
+	"github.com/google/go-cmp/cmp"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestInputSQL(t *testing.T) {
+
+	tests := map[string]struct {
+		column Column
+		want   string
+	}{
+		"one argument": {
+			Column{Name: "id", input: "int4in", nargs: 1, ioparam: 23, typmod: -1},
+			"SELECT count(int4in(v::cstring)) FROM unnest($1::text[]) AS v",
+		},
+		"type modifier": {
+			Column{Name: "code", input: "varcharin", nargs: 3, ioparam: 1043, typmod: 14},
+			"SELECT count(varcharin(v::cstring, 1043, 14)) FROM unnest($1::text[]) AS v",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, inputSQL(tc.column)); diff != "" {
+				t.Errorf("inputSQL() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProblemString(t *testing.T) {
+
+	tests := map[string]struct {
+		problem Problem
+		want    string
+	}{
+		"line and column": {Problem{Line: 3, Column: "amount", Message: "bad"}, `line 3, column "amount": bad`},
+		"line":            {Problem{Line: 3, Message: "bad"}, "line 3: bad"},
+		"column":          {Problem{Column: "amount", Message: "bad"}, `column "amount": bad`},
+		"file":            {Problem{Message: "bad"}, "bad"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tc.problem.String(); got != tc.want {
+				t.Errorf("String() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidatorRead(t *testing.T) {
+	f, err := ioutil.TempFile("", "*.csv")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err)
+	}
+	defer os.Remove(f.Name()) // clean up
+	f.WriteString("id,amount\n1,10\n2\n3,x\n4,\"4\"0\n5,50\n")
+	f.Close()
+
+	src, err := OpenSource(f.Name(), SourceOptions{Conversions: map[string]Conversion{"amount": {Type: "numeric"}}})
+	if err != nil {
+		t.Fatalf("OpenSource() failed: %s", err)
+	}
+	defer src.Close()
+
+	v := &validator{ctx: context.Background(), res: &Validation{}}
+	if err := v.read(src); err != nil {
+		t.Fatalf("read() failed: %s", err)
+	}
+
+	want := &Validation{
+		Records: 2,
+		Problems: []Problem{
+			{Line: 3, Message: "wrong number of fields"},
+			{Line: 4, Column: "amount", Message: `cannot convert "x" to numeric: not a number`},
+			{Line: 5, Message: `extraneous or missing " in quoted-field`},
+		},
+	}
+	if diff := cmp.Diff(want, v.res); diff != "" {
+		t.Errorf("read() mismatch (-want +got):\n%s", diff)
+	}
+
+	again, err := OpenSource(f.Name(), SourceOptions{})
+	if err != nil {
+		t.Fatalf("OpenSource() failed: %s", err)
+	}
+	defer again.Close()
+
+	v = &validator{ctx: context.Background(), opts: ValidateOptions{MaxProblems: 1}, res: &Validation{}}
+	if err := v.read(again); err != nil {
+		t.Fatalf("read() failed: %s", err)
+	}
+	if !v.res.Stopped || len(v.res.Problems) != 1 {
+		t.Errorf("read() with MaxProblems 1 = %+v, want stopped after one problem", v.res)
+	}
+}